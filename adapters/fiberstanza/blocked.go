@@ -0,0 +1,34 @@
+package fiberstanza
+
+import (
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/handlers/httphandler"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BlockedText sends the block message as plain text (the default BlockedHandler)
+func BlockedText(c *fiber.Ctx, guard *handlers.Guard) error {
+	setBlockedHeader(c, guard)
+	return c.SendString(guard.BlockMessage())
+}
+
+// BlockedJSON sends a JSON body (see httphandler.Blocked)
+func BlockedJSON(c *fiber.Ctx, guard *handlers.Guard) error {
+	setBlockedHeader(c, guard)
+	return c.JSON(httphandler.NewBlocked(guard))
+}
+
+// BlockedProblem sends an RFC 7807 application/problem+json body (see
+// httphandler.Problem)
+func BlockedProblem(c *fiber.Ctx, guard *handlers.Guard) error {
+	setBlockedHeader(c, guard)
+	return c.JSON(httphandler.NewProblem(guard, c.Path()), "application/problem+json")
+}
+
+func setBlockedHeader(c *fiber.Ctx, guard *handlers.Guard) {
+	if ra := httphandler.RetryAfter(guard); ra != "" {
+		c.Set("Retry-After", ra)
+	}
+	c.Status(httphandler.BlockedStatusCode(guard))
+}
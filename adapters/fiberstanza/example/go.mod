@@ -1,8 +1,8 @@
 module github.com/StanzaSystems/sdk-go/adapters/fiberstanza/example
 
-go 1.21
+go 1.22
 
-toolchain go1.21.0
+toolchain go1.22.4
 
 require (
 	github.com/StanzaSystems/sdk-go/adapters/fiberstanza v0.0.0-20231218143256-5a6f226dc24f
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/StanzaSystems/sdk-go/handlers"
 	"github.com/StanzaSystems/sdk-go/keys"
 	"github.com/StanzaSystems/sdk-go/logging"
 	"github.com/StanzaSystems/sdk-go/stanza"
@@ -23,11 +24,16 @@ type Client struct {
 	// Required
 	APIKey string // customer generated API key
 
+	// Optional alternatives to APIKey (for API key rotation)
+	APIKeyProvider func() (string, error) // returns the current API key
+	APIKeyFile     string                 // path to a file containing the API key
+
 	// Optional
 	Name        string   // defines applications name
 	Release     string   // defines applications version
 	Environment string   // defines applications environment
 	StanzaHub   string   // host:port (ipv4, ipv6, or resolvable hostname)
+	StanzaHubs  []string // failover hub endpoints, in priority order
 	Guard       []string // prefetch config for these guards
 }
 
@@ -38,11 +44,24 @@ type Opt struct {
 	PriorityBoost int32
 	DefaultWeight float32
 	Tags          map[string]string
+
+	// writes the response for blocked requests (default: BlockedText)
+	BlockedHandler func(*fiber.Ctx, *handlers.Guard) error
+
+	// fill in quota tags from requests (see stanza.TagFromHeader, etc)
+	TagExtractors []stanza.TagExtractor
 }
 
 // New creates a new fiberstanza middleware fiber.Handler
 func New(guardName string, opts ...Opt) fiber.Handler {
-	h, err := stanza.HttpServer(guardName, withOpts(opts...))
+	blocked := BlockedText
+	for _, opt := range opts {
+		if opt.BlockedHandler != nil {
+			blocked = opt.BlockedHandler
+		}
+	}
+
+	h, err := stanza.HttpServer(guardName, withOpts(opts...)...)
 	if err != nil {
 		logging.Error(fmt.Errorf("failed to create HTTP inbound handler: %v", err))
 		return func(c *fiber.Ctx) error {
@@ -72,44 +91,61 @@ func New(guardName string, opts ...Opt) fiber.Handler {
 			return c.Next()
 		}
 
+		// expose route parameters to TagFromPathValue
+		for _, p := range c.Route().Params {
+			req.SetPathValue(p, c.Params(p))
+		}
+
 		ctx, span, tokens := h.Start(&req)
 		defer span.End()
+		ctx = h.ExtractTags(ctx, handlers.HTTPTagSource(&req))
 
 		guard := h.Guard(ctx, span, tokens)
 		c.SetUserContext(guard.Context())
 
 		// Stanza Blocked
 		if guard.Blocked() {
-			span.SetAttributes(semconv.HTTPStatusCode(http.StatusTooManyRequests))
 			span.SetStatus(codes.Error, guard.BlockMessage())
-			c.SendString(guard.BlockMessage())
-			return c.SendStatus(http.StatusTooManyRequests)
+			err := blocked(c, guard)
+			span.SetAttributes(semconv.HTTPStatusCode(c.Response().StatusCode()))
+			return err
 		}
 
 		// Stanza Allowed
-		err := c.Next() // intercept c.Next() for guard.End() status
-		span.SetAttributes(semconv.HTTPStatusCode(c.Response().StatusCode()))
-		span.SetStatus(h.HTTPServerStatus(c.Response().StatusCode()))
+		err := c.Next() // intercept c.Next() for guard.EndWith() outcome
 		if err != nil {
 			span.RecordError(err)
 			// invokes the registered HTTP error handler
 			// to get the correct response status code
 			_ = c.App().Config().ErrorHandler(c, err)
-			guard.End(guard.Failure)
-		} else {
-			guard.End(guard.Success)
 		}
+		status := c.Response().StatusCode()
+		code, msg := h.HTTPServerStatus(status)
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		span.SetStatus(code, msg)
+		outcome := handlers.Outcome{Status: guard.Success, Err: err, Code: status, Bytes: responseSize(c)}
+		if err != nil || code == codes.Error {
+			outcome.Status = guard.Failure
+		}
+		guard.EndWith(outcome)
 		return nil
 	}
 }
 
-// Init is a fiberstanza helper function (passthrough to stanza.Init)
-func Init(ctx context.Context, client Client) (func(), error) {
-	exit, err := stanza.Init(ctx, stanza.ClientOptions(client))
-	if err != nil {
-		return nil, err
+// responseSize returns the size of the response body (0 if unknown), without
+// reading a body stream
+func responseSize(c *fiber.Ctx) int64 {
+	if c.Response().IsBodyStream() {
+		return max(int64(c.Response().Header.ContentLength()), 0)
 	}
-	return exit, nil
+	return int64(len(c.Response().Body()))
+}
+
+// Init is a fiberstanza helper function (passthrough to stanza.Init). Like
+// stanza.Init, the returned shutdown function is never nil, even with an error
+// (the SDK may keep running, e.g. until an unresolved hub endpoint resolves).
+func Init(ctx context.Context, client Client) (func(), error) {
+	return stanza.Init(ctx, stanza.ClientOptions(client))
 }
 
 // HttpGet is a fiberstanza helper function (passthrough to stanza.HttpGet)
@@ -117,7 +153,7 @@ func HttpGet(c *fiber.Ctx, guardName string, url string, opts ...Opt) (*http.Res
 	var req http.Request
 	fasthttpadaptor.ConvertRequest(c.Context(), &req, true)
 	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
-	return stanza.HttpGet(withHeaders(ctx, opts...), guardName, url, withOpts(opts...))
+	return stanza.HttpGet(withHeaders(ctx, opts...), guardName, url, withOpts(opts...)...)
 }
 
 // HttpPost is a fiberstanza helper function (passthrough to stanza.HttpPost)
@@ -125,33 +161,39 @@ func HttpPost(c *fiber.Ctx, guardName string, url string, body io.Reader, opts .
 	var req http.Request
 	fasthttpadaptor.ConvertRequest(c.Context(), &req, true)
 	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
-	return stanza.HttpPost(withHeaders(ctx, opts...), guardName, url, body, withOpts(opts...))
+	return stanza.HttpPost(withHeaders(ctx, opts...), guardName, url, body, withOpts(opts...)...)
 }
 
 func withHeaders(ctx context.Context, opts ...Opt) context.Context {
-	if len(opts) == 1 {
-		if opts[0].Headers != nil {
-			ctx = context.WithValue(ctx, keys.OutboundHeadersKey, opts[0].Headers)
+	for _, opt := range opts {
+		if opt.Headers != nil {
+			ctx = context.WithValue(ctx, keys.OutboundHeadersKey, opt.Headers)
 		}
 	}
 	return ctx
 }
 
-func withOpts(opts ...Opt) stanza.GuardOpt {
-	guardOpt := stanza.GuardOpt{}
-	if len(opts) == 1 {
-		if opts[0].Feature != "" {
-			guardOpt.Feature = &opts[0].Feature
+func withOpts(opts ...Opt) []stanza.Option {
+	guardOpts := []stanza.Option{}
+	for i := range opts {
+		opt := &opts[i]
+		guardOpt := stanza.GuardOpt{}
+		if opt.Feature != "" {
+			guardOpt.Feature = &opt.Feature
+		}
+		if opt.PriorityBoost != 0 {
+			guardOpt.PriorityBoost = &opt.PriorityBoost
 		}
-		if opts[0].PriorityBoost != 0 {
-			guardOpt.PriorityBoost = &opts[0].PriorityBoost
+		if opt.DefaultWeight != 0 {
+			guardOpt.DefaultWeight = &opt.DefaultWeight
 		}
-		if opts[0].DefaultWeight != 0 {
-			guardOpt.DefaultWeight = &opts[0].DefaultWeight
+		if len(opt.Tags) > 0 {
+			guardOpt.Tags = &opt.Tags
 		}
-		if len(opts[0].Tags) > 0 {
-			guardOpt.Tags = &opts[0].Tags
+		guardOpts = append(guardOpts, guardOpt)
+		if len(opt.TagExtractors) > 0 {
+			guardOpts = append(guardOpts, stanza.WithTagExtractors(opt.TagExtractors...))
 		}
 	}
-	return guardOpt
+	return guardOpts
 }
@@ -1,8 +1,8 @@
 module github.com/StanzaSystems/sdk-go/adapters/fiberstanza
 
-go 1.21
+go 1.22
 
-toolchain go1.21.0
+toolchain go1.22.4
 
 require (
 	github.com/StanzaSystems/sdk-go v0.0.5-beta
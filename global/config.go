@@ -13,7 +13,6 @@ import (
 	"github.com/StanzaSystems/sdk-go/otel"
 	"github.com/StanzaSystems/sdk-go/sentinel"
 
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -30,12 +29,20 @@ const GUARD_CONFIG_REFRESH_INTERVAL = 30 * time.Second
 const GUARD_CONFIG_REFRESH_JITTER = 6 // seconds
 
 func GetServiceConfig(ctx context.Context, skipPoll bool) {
-	if skipPoll || time.Now().After(gs.svcConfigTime.Add(jitter(SERVICE_CONFIG_REFRESH_INTERVAL, SERVICE_CONFIG_REFRESH_JITTER))) {
-		res, err := gs.hubConfigClient.GetServiceConfig(
-			metadata.NewOutgoingContext(ctx, XStanzaKey()),
+	gsLock.RLock()
+	client := gs.hubConfigClient
+	fetched := gs.svcConfigTime
+	versionSeen := gs.svcConfigVersion
+	gsLock.RUnlock()
+	if client == nil {
+		return
+	}
+	if skipPoll || time.Now().After(fetched.Add(jitter(SERVICE_CONFIG_REFRESH_INTERVAL, SERVICE_CONFIG_REFRESH_JITTER))) {
+		res, err := client.GetServiceConfig(
+			ctx,
 			&hubv1.GetServiceConfigRequest{
 				ClientId:    proto.String(GetClientID()),
-				VersionSeen: gs.svcConfigVersion,
+				VersionSeen: versionSeen,
 				Service: &hubv1.ServiceSelector{
 					Environment: gs.svcEnvironment,
 					Name:        gs.svcName,
@@ -46,91 +53,107 @@ func GetServiceConfig(ctx context.Context, skipPoll bool) {
 		if err != nil {
 			logging.Error(err)
 		}
-		if res.GetConfigDataSent() {
-			gsLock.Lock()
-			defer gsLock.Unlock()
-			errCount := 0
-			if gs.otelInit {
-				if gs.svcConfig.GetMetricConfig().String() != res.GetConfig().GetMetricConfig().String() ||
-					gs.svcConfig.GetTraceConfig().String() != res.GetConfig().GetTraceConfig().String() {
-					gs.svcConfig.MetricConfig = res.GetConfig().MetricConfig
-					gs.svcConfig.TraceConfig = res.GetConfig().TraceConfig
-					OtelStartup(ctx, true)
-					logging.Debug("accepted opentelemetry configs", "version", res.GetVersion())
+		if res.GetConfigDataSent() && acceptServiceConfig(res) {
+			// restart OTEL with the new metric and trace configs (OtelStartup
+			// takes the global state lock itself)
+			OtelStartup(ctx, true)
+			logging.Debug("accepted opentelemetry configs", "version", res.GetVersion())
+		}
+	}
+}
+
+// acceptServiceConfig stores a new service config (and its Sentinel rules),
+// returning true if OTEL needs to be restarted for its metric or trace config
+func acceptServiceConfig(res *hubv1.GetServiceConfigResponse) bool {
+	gsLock.Lock()
+	defer gsLock.Unlock()
+	restartOtel := false
+	errCount := 0
+	if gs.otelInit {
+		if gs.svcConfig.GetMetricConfig().String() != res.GetConfig().GetMetricConfig().String() ||
+			gs.svcConfig.GetTraceConfig().String() != res.GetConfig().GetTraceConfig().String() {
+			gs.svcConfig.MetricConfig = res.GetConfig().MetricConfig
+			gs.svcConfig.TraceConfig = res.GetConfig().TraceConfig
+			restartOtel = true
+		}
+	}
+	if gs.sentinelInit {
+		if sc := res.GetConfig().GetSentinelConfig(); sc != nil {
+			if rules := sc.GetCircuitbreakerRulesJson(); rules != "" {
+				if err := os.WriteFile(gs.sentinelRules["circuitbreaker"], []byte(rules), filePerms); err != nil {
+					logging.Error(err, "version", res.GetVersion())
+				}
+			}
+			if rules := sc.GetFlowRulesJson(); rules != "" {
+				if err := os.WriteFile(gs.sentinelRules["flow"], []byte(rules), filePerms); err != nil {
+					logging.Error(err, "version", res.GetVersion())
 				}
 			}
-			if gs.sentinelInit {
-				if sc := res.GetConfig().GetSentinelConfig(); sc != nil {
-					if rules := sc.GetCircuitbreakerRulesJson(); rules != "" {
-						if err := os.WriteFile(gs.sentinelRules["circuitbreaker"], []byte(rules), filePerms); err != nil {
-							logging.Error(err, "version", res.GetVersion())
-						}
-					}
-					if rules := sc.GetFlowRulesJson(); rules != "" {
-						if err := os.WriteFile(gs.sentinelRules["flow"], []byte(rules), filePerms); err != nil {
-							logging.Error(err, "version", res.GetVersion())
-						}
-					}
-					if rules := sc.GetIsolationRulesJson(); rules != "" {
-						if err := os.WriteFile(
-							gs.sentinelRules["isolation"], []byte(rules), filePerms); err != nil {
-							logging.Error(err, "version", res.GetVersion())
-						}
-					}
-					if rules := sc.GetSystemRulesJson(); rules != "" {
-						if err := os.WriteFile(gs.sentinelRules["system"], []byte(rules), filePerms); err != nil {
-							logging.Error(err, "version", res.GetVersion())
-						}
-					}
-					logging.Debug("accepted sentinel config", "version", res.GetVersion())
+			if rules := sc.GetIsolationRulesJson(); rules != "" {
+				if err := os.WriteFile(
+					gs.sentinelRules["isolation"], []byte(rules), filePerms); err != nil {
+					logging.Error(err, "version", res.GetVersion())
 				}
 			}
-			if errCount > 0 {
-				logging.Error(fmt.Errorf("rejected service config"), "version", res.GetVersion())
-			} else {
-				gs.svcConfig = res.GetConfig()
-				gs.svcConfigTime = time.Now()
-				gs.svcConfigVersion = res.GetVersion()
-				logging.Debug("accepted service config", "version", res.GetVersion())
+			if rules := sc.GetSystemRulesJson(); rules != "" {
+				if err := os.WriteFile(gs.sentinelRules["system"], []byte(rules), filePerms); err != nil {
+					logging.Error(err, "version", res.GetVersion())
+				}
 			}
+			logging.Debug("accepted sentinel config", "version", res.GetVersion())
 		}
 	}
+	if errCount > 0 {
+		logging.Error(fmt.Errorf("rejected service config"), "version", res.GetVersion())
+	} else {
+		gs.svcConfig = res.GetConfig()
+		gs.svcConfigTime = time.Now()
+		gs.svcConfigVersion = res.GetVersion()
+		logging.Debug("accepted service config", "version", res.GetVersion())
+	}
+	return restartOtel
 }
 
 func GetGuardConfigs(ctx context.Context, skipPoll bool) {
-	if len(gs.guardConfig) > 0 {
-		for guard := range gs.guardConfig {
-			if skipPoll || time.Now().After(
-				gs.guardConfigTime[guard].Add(
-					jitter(GUARD_CONFIG_REFRESH_INTERVAL, GUARD_CONFIG_REFRESH_JITTER))) {
-				_, _, err := fetchGuardConfig(ctx, guard)
-				if err != nil {
-					logging.Error(err)
-				}
-			}
+	// snapshot which guards are due, fetching them takes the lock itself
+	gs.guardConfigLock.RLock()
+	guards := make([]string, 0, len(gs.guardConfig))
+	for guard, fetched := range gs.guardConfigTime {
+		if skipPoll || time.Now().After(
+			fetched.Add(jitter(GUARD_CONFIG_REFRESH_INTERVAL, GUARD_CONFIG_REFRESH_JITTER))) {
+			guards = append(guards, guard)
+		}
+	}
+	gs.guardConfigLock.RUnlock()
+
+	for _, guard := range guards {
+		_, _, err := fetchGuardConfig(ctx, guard)
+		if err != nil {
+			logging.Error(err)
 		}
 	}
 }
 
 func fetchGuardConfig(ctx context.Context, guard string) (*hubv1.GuardConfig, hubv1.Config, error) {
-	gs.guardConfigLock.RLock()
-	_, ok := gs.guardConfig[guard]
-	gs.guardConfigLock.RUnlock()
-	if !ok {
-		gs.guardConfigLock.Lock()
+	gs.guardConfigLock.Lock()
+	if _, ok := gs.guardConfig[guard]; !ok {
 		gs.guardConfig[guard] = nil
 		gs.guardConfigTime[guard] = time.Time{}
 		gs.guardConfigVersion[guard] = ""
-		gs.guardConfigLock.Unlock()
 	}
+	versionSeen := gs.guardConfigVersion[guard]
+	gs.guardConfigLock.Unlock()
 
-	if gs.hubConfigClient == nil {
+	gsLock.RLock()
+	client := gs.hubConfigClient
+	gsLock.RUnlock()
+	if client == nil {
 		return nil, hubv1.Config_CONFIG_FETCH_ERROR, errors.New("hub config client unavailable")
 	}
-	res, err := gs.hubConfigClient.GetGuardConfig(
-		metadata.NewOutgoingContext(ctx, XStanzaKey()),
+	res, err := client.GetGuardConfig(
+		ctx,
 		&hubv1.GetGuardConfigRequest{
-			VersionSeen: proto.String(gs.guardConfigVersion[guard]),
+			VersionSeen: proto.String(versionSeen),
 			Selector: &hubv1.GuardServiceSelector{
 				Environment:    gs.svcEnvironment,
 				GuardName:      guard,
@@ -148,6 +171,7 @@ func fetchGuardConfig(ctx context.Context, guard string) (*hubv1.GuardConfig, hu
 		gs.guardConfigTime[guard] = time.Now()
 		gs.guardConfigVersion[guard] = res.GetVersion()
 		gs.guardConfigLock.Unlock()
+		guardConfigGeneration.Add(1)
 		logging.Debug("accepted guard config", "guard", guard, "version", res.GetVersion())
 		return res.GetConfig(), hubv1.Config_CONFIG_FETCHED_OK, nil
 	}
@@ -156,13 +180,24 @@ func fetchGuardConfig(ctx context.Context, guard string) (*hubv1.GuardConfig, hu
 
 func OtelStartup(ctx context.Context, skipPoll bool) {
 	if OtelEnabled() {
-		if skipPoll || time.Now().After(gs.otelTokenTime.Add(jitter(BEARER_TOKEN_REFRESH_INTERVAL, BEARER_TOKEN_REFRESH_JITTER))) {
-			if gs.svcConfig.MetricConfig == nil || gs.svcConfig.TraceConfig == nil {
+		gsLock.RLock()
+		client := gs.hubAuthClient
+		tokenTime := gs.otelTokenTime
+		metricConfig := gs.svcConfig.GetMetricConfig()
+		traceConfig := gs.svcConfig.GetTraceConfig()
+		gsLock.RUnlock()
+
+		if skipPoll || time.Now().After(tokenTime.Add(jitter(BEARER_TOKEN_REFRESH_INTERVAL, BEARER_TOKEN_REFRESH_JITTER))) {
+			if metricConfig == nil || traceConfig == nil {
 				logging.Error(fmt.Errorf("unable to setup opentelemetry, invalid metric or trace config"))
 				return
 			}
-			res, err := gs.hubAuthClient.GetBearerToken(
-				metadata.NewOutgoingContext(ctx, XStanzaKey()),
+			if client == nil {
+				logging.Error(fmt.Errorf("unable to setup opentelemetry, hub auth client unavailable"))
+				return
+			}
+			res, err := client.GetBearerToken(
+				ctx,
 				&hubv1.GetBearerTokenRequest{Environment: GetServiceEnvironment()})
 			if err != nil {
 				logging.Error(err)
@@ -177,9 +212,9 @@ func OtelStartup(ctx context.Context, skipPoll bool) {
 				ServiceName:        gs.svcName,
 				ServiceVersion:     gs.svcRelease,
 				ServiceEnvironment: gs.svcEnvironment,
-				MetricCollector:    gs.svcConfig.MetricConfig.GetCollectorUrl(),
-				TraceCollector:     gs.svcConfig.TraceConfig.GetCollectorUrl(),
-				TraceSampleRate:    float64(gs.svcConfig.TraceConfig.GetSampleRateDefault()),
+				MetricCollector:    metricConfig.GetCollectorUrl(),
+				TraceCollector:     traceConfig.GetCollectorUrl(),
+				TraceSampleRate:    float64(traceConfig.GetSampleRateDefault()),
 				Headers: map[string]string{
 					"Authorization": "Bearer " + res.GetBearerToken(),
 					"User-Agent":    UserAgent(),
@@ -197,11 +232,10 @@ func OtelStartup(ctx context.Context, skipPoll bool) {
 				return
 			}
 
-			// Replace global Stanza Meter
-			gs.otelStanzaMeter = NewStanzaMeter()
-
-			// Replace global Stanza Tracer
-			gs.otelStanzaTracer = NewStanzaTracer()
+			// Replace global Stanza Meter and Tracer (handlers load them
+			// without taking the global state lock)
+			otelStanzaMeter.Store(NewStanzaMeter())
+			otelStanzaTracer.Store(NewStanzaTracer())
 
 			// Run old OTEL shutdown function to cleanly shutdown the old
 			// meter and tracer
@@ -216,7 +250,10 @@ func OtelStartup(ctx context.Context, skipPoll bool) {
 }
 
 func SentinelStartup(ctx context.Context) {
-	if SentinelEnabled() && !gs.sentinelInit {
+	gsLock.RLock()
+	initialized := gs.sentinelInit
+	gsLock.RUnlock()
+	if SentinelEnabled() && !initialized {
 		done, err := sentinel.Init(gs.svcName, gs.sentinelRules)
 		if err != nil {
 			logging.Error(err)
@@ -0,0 +1,111 @@
+package global
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/StanzaSystems/sdk-go/logging"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Longest we back off hub polling for while our API key is being rejected
+const MAX_AUTH_BACKOFF = 5 * time.Minute
+
+// ErrAuth is returned (wrapped) for hub requests which were rejected because
+// of an invalid, expired, or revoked Stanza API key.
+var ErrAuth = errors.New("stanza hub rejected api key")
+
+type keyCredentials struct{}
+
+// PerRPCCredentials returns credentials.PerRPCCredentials which attach the
+// current Stanza API key (see GetServiceKey) to every request.
+func PerRPCCredentials() credentials.PerRPCCredentials {
+	return keyCredentials{}
+}
+
+func (keyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{stanzaKeyHeader: GetServiceKey()}, nil
+}
+
+// RequireTransportSecurity is false so STANZA_HUB_NO_TLS keeps working for
+// local hub development.
+func (keyCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// IsAuthError returns true if err is a hub auth failure (either
+// codes.Unauthenticated or codes.PermissionDenied).
+func IsAuthError(err error) bool {
+	if errors.Is(err, ErrAuth) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return true
+	}
+	return false
+}
+
+// authInterceptor tracks hub auth failures and, when a request fails with
+// codes.Unauthenticated, re-reads the Stanza API key (skipping the key file
+// check interval) and retries the request once if the key has changed (rotated).
+func authInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	key := GetServiceKey()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if status.Code(err) == codes.Unauthenticated {
+		recheckServiceKey()
+		if GetServiceKey() != key {
+			logging.Debug("retrying stanza hub request with rotated api key", "method", method)
+			err = invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	if IsAuthError(err) {
+		recordAuthError(err)
+	} else if err == nil {
+		clearAuthError()
+	}
+	return err
+}
+
+func recordAuthError(err error) {
+	gsLock.Lock()
+	defer gsLock.Unlock()
+	if gs.hubAuthFailures == 0 {
+		logging.Error(ErrAuth,
+			"error", err.Error(),
+			"uri", gs.hubURIs[gs.hubIndex])
+	}
+	gs.hubAuthError = err
+	gs.hubAuthFailures += 1
+}
+
+func clearAuthError() {
+	gsLock.RLock()
+	failing := gs.hubAuthFailures > 0
+	gsLock.RUnlock()
+	if failing {
+		gsLock.Lock()
+		gs.hubAuthError = nil
+		gs.hubAuthFailures = 0
+		gsLock.Unlock()
+		logging.Info("stanza hub accepted api key")
+	}
+}
+
+// pollDelay returns how long to wait before polling hub again, backing off
+// exponentially (up to MAX_AUTH_BACKOFF) while our API key is being rejected.
+func pollDelay(pollInterval time.Duration) time.Duration {
+	gsLock.RLock()
+	failures := gs.hubAuthFailures
+	gsLock.RUnlock()
+	delay := pollInterval
+	for i := 0; i < failures && delay < MAX_AUTH_BACKOFF; i++ {
+		delay *= 2
+	}
+	return min(delay, MAX_AUTH_BACKOFF)
+}
@@ -9,3 +9,9 @@ func OtelEnabled() bool {
 func SentinelEnabled() bool {
 	return os.Getenv("STANZA_NO_SENTINEL") == ""
 }
+
+// HubSelectLatency reports whether we connect to the hub endpoint which is
+// ready first, rather than the highest priority one which is ready
+func HubSelectLatency() bool {
+	return os.Getenv("STANZA_HUB_SELECTION") == "latency"
+}
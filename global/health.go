@@ -0,0 +1,30 @@
+package global
+
+// Health describes the current state of the SDK's connection to Stanza Hub.
+type Health struct {
+	HubURI       string   // active hub endpoint
+	HubState     string   // connectivity state of the active hub connection
+	HubEndpoints []string // all known hub endpoints, in priority order
+	HubFailovers int      // number of times we have failed over to another hub endpoint
+	AuthError    string   // set while hub is rejecting our API key
+}
+
+func GetHealth() Health {
+	gsLock.RLock()
+	defer gsLock.RUnlock()
+	h := Health{
+		HubState:     "DISCONNECTED",
+		HubEndpoints: append([]string{}, gs.hubURIs...),
+		HubFailovers: gs.hubFailovers,
+	}
+	if len(gs.hubURIs) > 0 {
+		h.HubURI = gs.hubURIs[gs.hubIndex]
+	}
+	if gs.hubAuthError != nil {
+		h.AuthError = gs.hubAuthError.Error()
+	}
+	if gs.hubConn != nil {
+		h.HubState = gs.hubConn.GetState().String()
+	}
+	return h
+}
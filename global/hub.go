@@ -3,9 +3,13 @@ package global
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"buf.build/gen/go/stanza/apis/grpc/go/stanza/hub/v1/hubv1grpc"
@@ -18,7 +22,214 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+const (
+	// Number of failed polling attempts before failing over to the next hub
+	// endpoint (4 attempts * 15 seconds == 1 minute)
+	HUB_FAILOVER_ATTEMPTS = 4
+
+	// How often we check if a higher priority hub endpoint is available again
+	HUB_FAILBACK_INTERVAL = 5 * time.Minute
+
+	// How long we wait for hub endpoints to become ready when connecting
+	HUB_CONNECT_TIMEOUT = 10 * time.Second
+
+	// Use client-side round robin load balancing across all addresses a hub
+	// endpoint resolves to (only applies to "dns:///" style endpoints)
+	hubServiceConfig = `{"loadBalancingConfig": [{"round_robin":{}}]}`
+)
+
+// ResolveHubs expands a list of hub endpoints (in priority order) into a list
+// of dialable "host:port" targets. Endpoints in the DNS SRV form of
+// "_service._proto.name" are looked up and replaced by their targets (in SRV
+// priority and weight order), everything else is passed through unchanged.
+// SRV names which fail to resolve are left out, and returned as the error.
+func ResolveHubs(hubs []string) ([]string, error) {
+	resolved := []string{}
+	var errs []error
+	for _, hub := range hubs {
+		hub = strings.TrimSpace(hub)
+		if hub == "" {
+			continue
+		}
+		if !isSRV(hub) {
+			resolved = append(resolved, hub)
+			continue
+		}
+		_, addrs, err := net.LookupSRV("", "", hub)
+		if err != nil {
+			logging.Error(err, "msg", "failed to resolve stanza hub SRV record", "uri", hub)
+			errs = append(errs, err)
+			continue
+		}
+		for _, addr := range addrs {
+			resolved = append(resolved,
+				net.JoinHostPort(strings.TrimSuffix(addr.Target, "."), strconv.Itoa(int(addr.Port))))
+		}
+	}
+	return resolved, errors.Join(errs...)
+}
+
+func isSRV(hub string) bool {
+	return strings.HasPrefix(hub, "_") && (strings.Contains(hub, "._tcp.") || strings.Contains(hub, "._udp."))
+}
+
+// resolveHubs resolves the configured hub endpoints again (SRV targets may
+// have moved), keeping the ones we have if nothing resolves
+func resolveHubs() []string {
+	gsLock.RLock()
+	endpoints := gs.hubEndpoints
+	gsLock.RUnlock()
+	uris, err := ResolveHubs(endpoints)
+	if len(uris) == 0 {
+		logging.Error(fmt.Errorf("no stanza hub endpoint could be resolved: %w", err), "uris", endpoints)
+		return nil
+	}
+	gsLock.Lock()
+	defer gsLock.Unlock()
+	if !slices.Equal(uris, gs.hubURIs) {
+		logging.Info("resolved stanza hub endpoints", "uris", uris)
+		current := ""
+		if len(gs.hubURIs) > 0 {
+			current = gs.hubURIs[gs.hubIndex]
+		}
+		gs.hubURIs = uris
+		gs.hubIndex = max(slices.Index(uris, current), 0)
+	}
+	return uris
+}
+
+// hubConnect connects to the best available hub endpoint: the highest
+// priority one which becomes ready within HUB_CONNECT_TIMEOUT (or with
+// STANZA_HUB_SELECTION=latency, the first one ready). Endpoints are dialed in
+// parallel, so unreachable ones don't hold up the others. If none is ready we
+// keep the active endpoint's connection, for hubPoller to retry.
 func hubConnect(ctx context.Context) {
+	gsLock.RLock()
+	uris, fallback := gs.hubURIs, gs.hubIndex
+	gsLock.RUnlock()
+	if len(uris) == 0 {
+		if uris = resolveHubs(); uris == nil {
+			return
+		}
+		fallback = 0
+	}
+	index, hubConn := selectHub(ctx, uris, fallback, HubSelectLatency())
+	if hubConn == nil {
+		return
+	}
+	useHub(ctx, uris, index, hubConn)
+}
+
+// hubProbe is the outcome of dialing one hub endpoint
+type hubProbe struct {
+	index   int
+	hubConn *grpc.ClientConn // nil if dialing failed
+	ready   bool
+}
+
+// selectHub dials every endpoint in uris (in parallel), returning the index and
+// connection of the one to use, the fallback endpoint if none became ready
+// (with a nil connection if it couldn't even be dialed). Connections to the
+// other endpoints are closed.
+func selectHub(ctx context.Context, uris []string, fallback int, latency bool) (int, *grpc.ClientConn) {
+	ctxWait, ctxWaitCancel := context.WithTimeout(ctx, HUB_CONNECT_TIMEOUT)
+	defer ctxWaitCancel()
+	probes := make(chan hubProbe, len(uris))
+	for i, uri := range uris {
+		go func(i int, uri string) {
+			probes <- probeHub(ctxWait, i, uri)
+		}(i, uri)
+	}
+
+	results := make([]*hubProbe, len(uris))
+	chosen, pending := -1, len(uris)
+	for ; pending > 0 && chosen < 0; pending-- {
+		p := <-probes
+		results[p.index] = &p
+		if latency {
+			if p.ready {
+				chosen = p.index
+			}
+			continue
+		}
+		// the highest priority ready endpoint, once every endpoint before it failed
+		for i, r := range results {
+			if r == nil {
+				break
+			}
+			if r.ready {
+				chosen = i
+				break
+			}
+		}
+	}
+	if chosen < 0 {
+		chosen = min(fallback, len(uris)-1)
+	}
+
+	for i, r := range results {
+		if r != nil && r.hubConn != nil && i != chosen {
+			r.hubConn.Close()
+		}
+	}
+	// the rest give up when ctxWait is cancelled
+	go func(pending int) {
+		for ; pending > 0; pending-- {
+			if p := <-probes; p.hubConn != nil {
+				p.hubConn.Close()
+			}
+		}
+	}(pending)
+	return chosen, results[chosen].hubConn
+}
+
+// probeHub dials a hub endpoint, waiting until it is ready, fails, or ctx is done
+func probeHub(ctx context.Context, index int, uri string) hubProbe {
+	hubConn, err := grpc.Dial(uri, hubDialOptions()...)
+	if err != nil {
+		logging.Error(err,
+			"msg", "failed to connect to stanza hub",
+			"uri", uri)
+		return hubProbe{index: index}
+	}
+	hubConn.Connect()
+	for {
+		switch state := hubConn.GetState(); state {
+		case connectivity.Ready:
+			return hubProbe{index: index, hubConn: hubConn, ready: true}
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return hubProbe{index: index, hubConn: hubConn}
+		default:
+			if !hubConn.WaitForStateChange(ctx, state) {
+				return hubProbe{index: index, hubConn: hubConn}
+			}
+		}
+	}
+}
+
+// useHub makes hubConn (to uris[index]) the active hub connection, and if it
+// is ready fetches our configs from it
+func useHub(ctx context.Context, uris []string, index int, hubConn *grpc.ClientConn) {
+	gsLock.Lock()
+	discardHubConn()
+	gs.hubURIs = uris
+	gs.hubIndex = index
+	gs.hubConn = hubConn
+	gs.hubAuthClient = hubv1grpc.NewAuthServiceClient(hubConn)
+	gs.hubConfigClient = hubv1grpc.NewConfigServiceClient(hubConn)
+	gs.hubQuotaClient = hubv1grpc.NewQuotaServiceClient(hubConn)
+	gsLock.Unlock()
+
+	if hubConn.GetState() == connectivity.Ready {
+		logging.Info("connected to stanza hub", "uri", uris[index])
+		GetServiceConfig(ctx, true)
+		GetGuardConfigs(ctx, true)
+		OtelStartup(ctx, true)
+		SentinelStartup(ctx)
+	}
+}
+
+func hubDialOptions() []grpc.DialOption {
 	tlsConfig := &tls.Config{}
 	if caPath := os.Getenv("STANZA_AWS_ROOT_CA"); caPath != "" {
 		tlsConfig.RootCAs = ca.AWSRootCAs(caPath)
@@ -27,56 +238,120 @@ func hubConnect(ctx context.Context) {
 	if os.Getenv("STANZA_HUB_NO_TLS") != "" { // disable TLS for local Hub development
 		creds = insecure.NewCredentials()
 	}
-	opts := []grpc.DialOption{
+	return []grpc.DialOption{
 		grpc.WithTransportCredentials(creds),
 		grpc.WithUserAgent(UserAgent()),
+		grpc.WithDefaultServiceConfig(hubServiceConfig),
+		grpc.WithPerRPCCredentials(PerRPCCredentials()),
+		grpc.WithChainUnaryInterceptor(authInterceptor),
 		// todo: add keepalives, backoff config, etc
 	}
-	hubConn, err := grpc.Dial(gs.hubURI, opts...)
-	if err != nil {
-		logging.Error(err,
-			"msg", "failed to connect to stanza hub",
-			"url", gs.hubURI)
-	} else {
-		gsLock.Lock()
-		gs.hubConn = hubConn
-		gs.hubAuthClient = hubv1grpc.NewAuthServiceClient(hubConn)
-		gs.hubConfigClient = hubv1grpc.NewConfigServiceClient(hubConn)
-		gs.hubQuotaClient = hubv1grpc.NewQuotaServiceClient(hubConn)
-		gsLock.Unlock()
-
-		// attempt to establish hub connection (doesn't block)
-		gs.hubConn.Connect()
-
-		// block, waiting for up to 10 seconds for hub connection
-		ctxWait, ctxWaitCancel := context.WithTimeout(ctx, 10*time.Second)
-		defer ctxWaitCancel()
-		gs.hubConn.WaitForStateChange(ctxWait, connectivity.Connecting)
-		if gs.hubConn.GetState() == connectivity.Ready {
-			logging.Info("connected to stanza hub", "uri", gs.hubURI)
-			GetServiceConfig(ctx, true)
-			GetGuardConfigs(ctx, true)
-			OtelStartup(ctx, true)
-			SentinelStartup(ctx)
+}
+
+// hubFailover closes the current hub connection (if any) and makes the next
+// hub endpoint (in priority order) the active one, after resolving the
+// endpoints again.
+func hubFailover() {
+	resolveHubs()
+	gsLock.Lock()
+	defer gsLock.Unlock()
+	from := gs.hubURIs[gs.hubIndex]
+	gs.hubIndex = (gs.hubIndex + 1) % len(gs.hubURIs)
+	gs.hubFailovers += 1
+	discardHubConn()
+	logging.Warn("failing over to next stanza hub",
+		"from", from,
+		"uri", gs.hubURIs[gs.hubIndex])
+}
+
+// hubFailback checks whether the primary hub endpoint is reachable again and,
+// if it is, reconnects to it.
+func hubFailback(ctx context.Context) {
+	gsLock.RLock()
+	uris := gs.hubURIs
+	from := gs.hubURIs[gs.hubIndex]
+	gsLock.RUnlock()
+
+	ctxWait, ctxWaitCancel := context.WithTimeout(ctx, HUB_CONNECT_TIMEOUT)
+	defer ctxWaitCancel()
+	probe := probeHub(ctxWait, 0, uris[0])
+	if !probe.ready {
+		if probe.hubConn != nil {
+			probe.hubConn.Close()
 		}
+		return
+	}
+	logging.Info("failing back to primary stanza hub", "from", from, "uri", uris[0])
+	useHub(ctx, uris, 0, probe.hubConn)
+}
+
+func currentHub() string {
+	gsLock.RLock()
+	defer gsLock.RUnlock()
+	if len(gs.hubURIs) == 0 {
+		return ""
+	}
+	return gs.hubURIs[gs.hubIndex]
+}
+
+// hubCount returns how many (resolved) hub endpoints we have
+func hubCount() int {
+	gsLock.RLock()
+	defer gsLock.RUnlock()
+	return len(gs.hubURIs)
+}
+
+// onPrimaryHub reports whether the active hub endpoint is the primary one
+func onPrimaryHub() bool {
+	gsLock.RLock()
+	defer gsLock.RUnlock()
+	return gs.hubIndex == 0
+}
+
+// hubConnection returns the current hub connection (nil if there isn't one)
+func hubConnection() *grpc.ClientConn {
+	gsLock.RLock()
+	defer gsLock.RUnlock()
+	return gs.hubConn
+}
+
+// closeHubConn closes and discards the current hub connection, returning
+// false if there wasn't one
+func closeHubConn() bool {
+	gsLock.Lock()
+	defer gsLock.Unlock()
+	return discardHubConn()
+}
+
+// discardHubConn is closeHubConn for callers already holding gsLock. The hub
+// clients go with the connection, so callers fail open until we reconnect
+// (rather than getting errors from a closed connection).
+func discardHubConn() bool {
+	if gs.hubConn == nil {
+		return false
 	}
+	gs.hubConn.Close()
+	gs.hubConn = nil
+	gs.hubAuthClient = nil
+	gs.hubConfigClient = nil
+	gs.hubQuotaClient = nil
+	return true
 }
 
 func hubPoller(ctx context.Context, pollInterval time.Duration) {
 	connectAttempt := 0
+	lastFailback := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
-			gs.otelShutdown(ctx)
-			gs.sentinelShutdown(ctx)
 			return
-		case <-time.After(pollInterval):
-			if gs.hubConn != nil {
-				if gs.hubConn.GetState() == connectivity.Ready {
+		case <-time.After(pollDelay(pollInterval)):
+			if hubConn := hubConnection(); hubConn != nil {
+				if hubConn.GetState() == connectivity.Ready {
 					if connectAttempt > 0 {
 						logging.Info(
 							"connected to stanza hub",
-							"uri", gs.hubURI,
+							"uri", currentHub(),
 							"attempt", connectAttempt,
 						)
 						connectAttempt = 0
@@ -85,27 +360,40 @@ func hubPoller(ctx context.Context, pollInterval time.Duration) {
 					GetGuardConfigs(ctx, false)
 					OtelStartup(ctx, false)
 					SentinelStartup(ctx)
+
+					// periodically try to move back to our primary hub
+					if !HubSelectLatency() && !onPrimaryHub() && time.Since(lastFailback) > HUB_FAILBACK_INTERVAL {
+						lastFailback = time.Now()
+						hubFailback(ctx)
+					}
+				} else if hubCount() > 1 && connectAttempt >= HUB_FAILOVER_ATTEMPTS {
+					// we have other hubs to try, discard this connection and let
+					// hubConnect() connect to the next one on the next loop
+					connectAttempt = 0
+					lastFailback = time.Now()
+					hubFailover()
 				} else {
 					// 120 attempts * 15 seconds == 1800 seconds == 30 minutes
 					if connectAttempt > 120 {
 						// if we have been stuck trying to connect for a "long time",
-						// discard the virtual connection handle and let hubConnect()
+						// close the virtual connection handle and let hubConnect()
 						// create a new one on the next loop
 						connectAttempt = 0
-						gs.hubConn = nil
+						closeHubConn()
 					} else {
 						connectAttempt += 1
+						uri := currentHub()
 						logging.Error(
 							fmt.Errorf("unable to connect to stanza hub"),
-							"uri", gs.hubURI,
+							"uri", uri,
 							"attempt", connectAttempt,
 						)
-						host, _, _ := net.SplitHostPort(gs.hubURI)
+						host, _, _ := net.SplitHostPort(uri)
 						_, err := net.LookupHost(host)
 						if err != nil {
 							logging.Error(err)
 						}
-						gs.hubConn.Connect()
+						hubConn.Connect()
 					}
 				}
 			} else {
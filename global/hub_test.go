@@ -0,0 +1,97 @@
+package global
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"buf.build/gen/go/stanza/apis/grpc/go/stanza/hub/v1/hubv1grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// listen starts an empty gRPC server, returning its address
+func listen(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// unreachable returns an address nothing is listening on
+func unreachable(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+func TestResolveHubs(t *testing.T) {
+	uris, err := ResolveHubs([]string{" hub1:9020", "", "dns:///hub2:9020"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hub1:9020", "dns:///hub2:9020"}, uris)
+	assert.True(t, isSRV("_stanza._tcp.example.com"))
+	assert.False(t, isSRV("hub.example.com:9020"))
+}
+
+func TestSelectHub(t *testing.T) {
+	t.Setenv("STANZA_HUB_NO_TLS", "true")
+	ctx := context.Background()
+	up1, up2, down := listen(t), listen(t), unreachable(t)
+
+	// the highest priority endpoint which is ready
+	i, conn := selectHub(ctx, []string{down, up1, up2}, 0, false)
+	assert.Equal(t, 1, i)
+	assert.NotNil(t, conn)
+	conn.Close()
+
+	// any ready endpoint, without waiting for the others
+	i, conn = selectHub(ctx, []string{down, up1}, 0, true)
+	assert.Equal(t, 1, i)
+	assert.NotNil(t, conn)
+	conn.Close()
+
+	// nothing ready, keep the fallback endpoint's connection for retrying
+	i, conn = selectHub(ctx, []string{down, unreachable(t)}, 1, false)
+	assert.Equal(t, 1, i)
+	assert.NotNil(t, conn)
+	conn.Close()
+}
+
+func TestDiscardHubConn(t *testing.T) {
+	t.Setenv("STANZA_HUB_NO_TLS", "true")
+	_, conn := selectHub(context.Background(), []string{listen(t)}, 0, false)
+
+	gsLock.Lock()
+	prev := gs
+	gs = state{
+		hubConn:         conn,
+		hubAuthClient:   hubv1grpc.NewAuthServiceClient(conn),
+		hubConfigClient: hubv1grpc.NewConfigServiceClient(conn),
+		hubQuotaClient:  hubv1grpc.NewQuotaServiceClient(conn),
+	}
+	gsLock.Unlock()
+	t.Cleanup(func() {
+		gsLock.Lock()
+		gs = prev
+		gsLock.Unlock()
+	})
+	assert.NotNil(t, QuotaServiceClient())
+
+	// the clients go with the connection, so callers fail open until we reconnect
+	gsLock.Lock()
+	assert.True(t, discardHubConn())
+	assert.Nil(t, gs.hubConn)
+	assert.Nil(t, gs.hubAuthClient)
+	assert.Nil(t, gs.hubConfigClient)
+	assert.Nil(t, gs.hubQuotaClient)
+	gsLock.Unlock()
+	assert.Nil(t, QuotaServiceClient())
+}
@@ -0,0 +1,97 @@
+package global
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/StanzaSystems/sdk-go/logging"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	stanzaKeyHeader = "x-stanza-key"
+
+	// How often KeyFileProvider checks whether the key file was modified
+	KEY_FILE_CHECK_INTERVAL = 10 * time.Second
+)
+
+// bumped by recheckServiceKey, so every KeyFileProvider checks its file again
+// on its next call (regardless of KEY_FILE_CHECK_INTERVAL)
+var keyFileGeneration atomic.Uint64
+
+// KeyFileProvider returns an API key provider which reads the key from the
+// given file, re-reading it whenever the file is modified. The file is checked
+// for changes at most once per KEY_FILE_CHECK_INTERVAL (not on every call),
+// unless the hub rejected the key (see authInterceptor).
+func KeyFileProvider(path string) func() (string, error) {
+	var mu sync.Mutex
+	var key string
+	var modTime, checked time.Time
+	var generation uint64
+	return func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		current := keyFileGeneration.Load()
+		if key != "" && generation == current && time.Since(checked) < KEY_FILE_CHECK_INTERVAL {
+			return key, nil
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		checked, generation = time.Now(), current
+		if key == "" || !fi.ModTime().Equal(modTime) {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			key = strings.TrimSpace(string(b))
+			modTime = fi.ModTime()
+		}
+		return key, nil
+	}
+}
+
+// GetServiceKey returns the current Stanza API key. If a key provider was
+// configured it is consulted every time, so a rotated key is picked up on the
+// next hub request; if the provider fails we keep using the last good key.
+func GetServiceKey() string {
+	gsLock.RLock()
+	provider := gs.svcKeyProvider
+	key := gs.svcKey
+	gsLock.RUnlock()
+	if provider == nil {
+		return key
+	}
+
+	newKey, err := provider()
+	if err != nil {
+		logging.Error(err, "msg", "failed to read stanza api key, using previous key")
+		return key
+	}
+	if newKey != "" && newKey != key {
+		gsLock.Lock()
+		gs.svcKey = newKey
+		gsLock.Unlock()
+		if key != "" {
+			logging.Info("using rotated stanza api key")
+		}
+		return newKey
+	}
+	return key
+}
+
+// recheckServiceKey makes the next GetServiceKey re-read a key file right away
+func recheckServiceKey() {
+	keyFileGeneration.Add(1)
+}
+
+// XStanzaKey returns the current Stanza API key as gRPC metadata. Hub requests
+// get the key via PerRPCCredentials, this is kept for compatibility.
+func XStanzaKey() metadata.MD {
+	return metadata.New(map[string]string{stanzaKeyHeader: GetServiceKey()})
+}
@@ -0,0 +1,63 @@
+package global
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestKeyFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	assert.NoError(t, os.WriteFile(path, []byte("key-1\n"), filePerms))
+	provider := KeyFileProvider(path)
+	key, err := provider()
+	assert.NoError(t, err)
+	assert.Equal(t, "key-1", key)
+
+	// rotated, but not picked up until the file is checked again
+	assert.NoError(t, os.WriteFile(path, []byte("key-2\n"), filePerms))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	key, err = provider()
+	assert.NoError(t, err)
+	assert.Equal(t, "key-1", key)
+
+	_, err = KeyFileProvider(filepath.Join(t.TempDir(), "missing"))()
+	assert.Error(t, err)
+}
+
+func TestAuthInterceptorRotatedKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	assert.NoError(t, os.WriteFile(path, []byte("key-1\n"), filePerms))
+	gsLock.Lock()
+	prevKey, prevProvider := gs.svcKey, gs.svcKeyProvider
+	gs.svcKey, gs.svcKeyProvider = "", KeyFileProvider(path)
+	gsLock.Unlock()
+	t.Cleanup(func() {
+		gsLock.Lock()
+		gs.svcKey, gs.svcKeyProvider = prevKey, prevProvider
+		gsLock.Unlock()
+	})
+	assert.Equal(t, "key-1", GetServiceKey())
+
+	// rotated within the check interval, the hub rejecting key-1 re-reads it
+	assert.NoError(t, os.WriteFile(path, []byte("key-2\n"), filePerms))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	sent := []string{}
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		key := GetServiceKey()
+		sent = append(sent, key)
+		if key != "key-2" {
+			return status.Error(codes.Unauthenticated, "invalid api key")
+		}
+		return nil
+	}
+	assert.NoError(t, authInterceptor(context.Background(), "/test", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"key-1", "key-2"}, sent)
+}
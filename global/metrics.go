@@ -14,6 +14,7 @@ const (
 	stanzaAllowedFailure  = "stanza.guard.allowed.failure"  // counter
 	stanzaAllowedUnknown  = "stanza.guard.allowed.unknown"  // counter
 	stanzaAllowedDuration = "stanza.guard.allowed.duration" // histogram (milliseconds)
+	stanzaAllowedBytes    = "stanza.guard.allowed.bytes"    // histogram (bytes)
 	stanzaBlocked         = "stanza.guard.blocked"          // counter
 	stanzaFailOpen        = "stanza.guard.failopen"         // counter
 )
@@ -24,6 +25,7 @@ type StanzaMeter struct {
 	AllowedFailureCount metric.Int64Counter
 	AllowedUnknownCount metric.Int64Counter
 	AllowedDuration     metric.Float64Histogram
+	AllowedBytes        metric.Int64Histogram
 	BlockedCount        metric.Int64Counter
 	FailOpenCount       metric.Int64Counter
 }
@@ -63,6 +65,10 @@ func NewStanzaMeter() *StanzaMeter {
 		stanzaAllowedDuration,
 		metric.WithUnit("ms"),
 		metric.WithDescription("measures the total executions time of guarded requests"))
+	m.AllowedBytes, _ = om.Int64Histogram(
+		stanzaAllowedBytes,
+		metric.WithUnit("By"),
+		metric.WithDescription("measures the response size of guarded requests"))
 	m.BlockedCount, _ = om.Int64Counter(
 		stanzaBlocked,
 		metric.WithUnit("1"),
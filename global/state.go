@@ -2,12 +2,13 @@ package global
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
 	"path/filepath"
+	"slices"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"buf.build/gen/go/stanza/apis/grpc/go/stanza/hub/v1/hubv1grpc"
@@ -18,7 +19,6 @@ import (
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 )
 
 const (
@@ -26,17 +26,33 @@ const (
 	instrumentationVersion = "0.0.5-beta"
 
 	MIN_POLLING_TIME = 15 * time.Second
+	SHUTDOWN_TIMEOUT = 5 * time.Second
 
 	filePerms = 0660
 )
 
 type state struct {
-	clientId       uuid.UUID
+	// lifecycle, derived from the context passed to NewState
+	ctx           context.Context
+	cancel        context.CancelFunc
+	shutdownFuncs []func(context.Context)
+
+	clientId       string
 	svcKey         string
+	svcKeyProvider func() (string, error)
 	svcName        string
 	svcEnvironment string
 	svcRelease     string
-	hubURI         string
+
+	// hub endpoints, in priority order (as configured, and with SRV names resolved)
+	hubEndpoints []string
+	hubURIs      []string
+	hubIndex     int
+	hubFailovers int
+
+	// hub auth failures (rejected API key)
+	hubAuthError    error
+	hubAuthFailures int
 
 	// stored after hubConnect success
 	hubConn         *grpc.ClientConn
@@ -56,11 +72,9 @@ type state struct {
 	guardConfigLock    *sync.RWMutex
 
 	// otel
-	otelInit         bool
-	otelShutdown     func(context.Context) error
-	otelTokenTime    time.Time
-	otelStanzaMeter  *StanzaMeter
-	otelStanzaTracer *trace.Tracer
+	otelInit      bool
+	otelShutdown  func(context.Context) error
+	otelTokenTime time.Time
 
 	// sentinel
 	sentinelInit       bool
@@ -74,21 +88,61 @@ var (
 	gs       = state{}
 	gsLock   = &sync.RWMutex{}
 	initOnce sync.Once
+
+	// guards registered before NewState was called
+	pendingGuards     = []string{}
+	pendingGuardsLock = &sync.Mutex{}
+
+	// bumped whenever any cached guard config changes
+	guardConfigGeneration atomic.Uint64
+
+	// read on every request, replaced whenever OTEL is (re)started
+	otelStanzaMeter  atomic.Pointer[StanzaMeter]
+	otelStanzaTracer atomic.Pointer[trace.Tracer]
 )
 
-func NewState(ctx context.Context, hubUri, svcKey, svcName, svcEnv, svcRel string, guards []string) func() {
-	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+// ErrInitialized is returned by NewState when the global state already exists
+var ErrInitialized = errors.New("stanza sdk already initialized")
+
+// ErrNotInitialized is returned for guard configs requested before Init
+var ErrNotInitialized = errors.New("stanza sdk not initialized")
+
+// NewState initializes the global state and starts background polling of
+// Stanza Hub. Everything started here lives until ctx is done or until the
+// returned shutdown function is called, whichever happens first. The global
+// state can only be initialized once, later calls return a no-op shutdown
+// function and ErrInitialized. If none of the hub endpoints resolve, the
+// error says so, but the SDK keeps running (and retrying) regardless.
+func NewState(ctx context.Context, hubUris []string, svcKey string, svcKeyProvider func() (string, error), svcName, svcEnv, svcRel string, guards []string) (func(), error) {
+	done := func() {}
+	err := ErrInitialized
 
 	// initialize new global state
 	initOnce.Do(func() {
+		ctx, cancel := context.WithCancel(ctx)
+		done = func() {
+			cancel()
+			shutdown()
+		}
+		hubURIs, resolveErr := ResolveHubs(hubUris)
+		err = nil
+		if len(hubURIs) == 0 {
+			err = fmt.Errorf("no stanza hub endpoint could be resolved: %w", resolveErr)
+			logging.Error(err, "uris", hubUris)
+		}
+
 		gsLock.Lock()
 		gs = state{
-			hubURI:             hubUri,
+			ctx:                ctx,
+			cancel:             cancel,
+			hubEndpoints:       hubUris,
+			hubURIs:            hubURIs,
 			svcKey:             svcKey,
+			svcKeyProvider:     svcKeyProvider,
 			svcName:            svcName,
 			svcEnvironment:     svcEnv,
 			svcRelease:         svcRel,
-			clientId:           uuid.New(),
+			clientId:           uuid.New().String(),
 			hubConn:            nil,
 			svcConfig:          &hubv1.ServiceConfig{},
 			svcConfigTime:      time.Time{},
@@ -100,13 +154,13 @@ func NewState(ctx context.Context, hubUri, svcKey, svcName, svcEnv, svcRel strin
 			otelInit:           false,
 			otelShutdown:       func(context.Context) error { return nil },
 			otelTokenTime:      time.Time{},
-			otelStanzaMeter:    NewStanzaMeter(),
-			otelStanzaTracer:   NewStanzaTracer(),
 			sentinelInit:       false,
 			sentinelShutdown:   func(context.Context) error { return nil },
 			sentinelRulesLock:  &sync.RWMutex{},
 		}
 		gsLock.Unlock()
+		otelStanzaMeter.Store(NewStanzaMeter())
+		otelStanzaTracer.Store(NewStanzaTracer())
 
 		// pre-create empty sentinel rules files
 		gs.sentinelRulesLock.Lock()
@@ -125,6 +179,10 @@ func NewState(ctx context.Context, hubUri, svcKey, svcName, svcEnv, svcRel strin
 		}
 		gs.sentinelRulesLock.Unlock()
 
+		pendingGuardsLock.Lock()
+		guards = append(guards, pendingGuards...)
+		pendingGuards = []string{}
+		pendingGuardsLock.Unlock()
 		if len(guards) > 0 {
 			for _, guard := range guards {
 				gs.guardConfigLock.Lock()
@@ -133,41 +191,83 @@ func NewState(ctx context.Context, hubUri, svcKey, svcName, svcEnv, svcRel strin
 				gs.guardConfigVersion[guard] = ""
 				gs.guardConfigLock.Unlock()
 			}
+			guardConfigGeneration.Add(1)
 		}
 
 		// connect to stanza-hub
-		if gs.hubConn == nil {
+		if hubConnection() == nil {
 			hubConnect(ctx)
 		}
 
 		// start background polling for updates
 		go hubPoller(ctx, MIN_POLLING_TIME)
+
+		// shutdown when the caller's context is done
+		go func() {
+			<-ctx.Done()
+			shutdown()
+		}()
 	})
-	return func() {
-		stop()
-		if gs.hubConn != nil {
-			gs.hubConn.Close()
-			logging.Debug("disconnected from stanza hub", "uri", gs.hubURI)
+	return done, err
+}
+
+var shutdownOnce sync.Once
+
+// shutdown runs registered shutdown functions, stops OTEL and Sentinel, and
+// closes the hub connection. It is safe to call more than once, only the first
+// call does any work (and concurrent callers wait for it to finish).
+func shutdown() {
+	shutdownOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+
+		gsLock.RLock()
+		shutdownFuncs := gs.shutdownFuncs
+		otelShutdown := gs.otelShutdown
+		sentinelShutdown := gs.sentinelShutdown
+		gsLock.RUnlock()
+
+		for _, fn := range shutdownFuncs {
+			fn(ctx)
 		}
-	}
+		if otelShutdown != nil {
+			otelShutdown(ctx)
+		}
+		if sentinelShutdown != nil {
+			sentinelShutdown(ctx)
+		}
+		if closeHubConn() {
+			logging.Debug("disconnected from stanza hub", "uri", currentHub())
+		}
+	})
 }
 
-func GetCustomerID() string {
+// Done returns a channel that is closed when the SDK is shutting down.
+func Done() <-chan struct{} {
 	gsLock.RLock()
 	defer gsLock.RUnlock()
-	return gs.svcConfig.GetCustomerId()
+	if gs.ctx == nil {
+		return nil
+	}
+	return gs.ctx.Done()
 }
 
-func GetClientID() string {
-	return gs.clientId.String()
+// OnShutdown registers fn to be called (before the hub connection is closed)
+// when the SDK is shutting down.
+func OnShutdown(fn func(context.Context)) {
+	gsLock.Lock()
+	defer gsLock.Unlock()
+	gs.shutdownFuncs = append(gs.shutdownFuncs, fn)
 }
 
-func GetServiceKey() string {
-	return gs.svcKey
+func GetCustomerID() string {
+	gsLock.RLock()
+	defer gsLock.RUnlock()
+	return gs.svcConfig.GetCustomerId()
 }
 
-func XStanzaKey() metadata.MD {
-	return metadata.New(map[string]string{"x-stanza-key": gs.svcKey})
+func GetClientID() string {
+	return gs.clientId
 }
 
 func GetServiceName() string {
@@ -183,14 +283,59 @@ func GetServiceRelease() string {
 }
 
 func GetStanzaMeter() *StanzaMeter {
-	return gs.otelStanzaMeter
+	return otelStanzaMeter.Load()
 }
 
 func GetStanzaTracer() *trace.Tracer {
-	return gs.otelStanzaTracer
+	return otelStanzaTracer.Load()
+}
+
+// Initialized reports whether NewState has initialized the global state
+func Initialized() bool {
+	gsLock.RLock()
+	defer gsLock.RUnlock()
+	return gs.guardConfigLock != nil
+}
+
+// RegisterGuard adds a guard to the set of guards whose config we prefetch and
+// keep polling for, so requests don't have to wait for a hub round trip the
+// first time the guard is used. Guard configs are fetched in the background,
+// guards registered before NewState are fetched along with the initial configs.
+func RegisterGuard(guard string) {
+	// hold pendingGuardsLock while checking, so NewState can't flush the
+	// pending guards between our check and the append
+	pendingGuardsLock.Lock()
+	gsLock.RLock()
+	initialized := gs.guardConfigLock != nil
+	ctx := gs.ctx
+	gsLock.RUnlock()
+	if !initialized {
+		if !slices.Contains(pendingGuards, guard) {
+			pendingGuards = append(pendingGuards, guard)
+		}
+		pendingGuardsLock.Unlock()
+		return
+	}
+	pendingGuardsLock.Unlock()
+
+	gs.guardConfigLock.RLock()
+	_, ok := gs.guardConfig[guard]
+	gs.guardConfigLock.RUnlock()
+	if !ok {
+		go func() {
+			if _, _, err := fetchGuardConfig(ctx, guard); err != nil {
+				logging.Debug("failed to prefetch guard config", "guard", guard, "error", err.Error())
+			}
+		}()
+	}
 }
 
+// GetGuardConfig returns the cached guard config, fetching it from Stanza Hub
+// if it isn't cached yet (ErrNotInitialized before Init, so guards fail open)
 func GetGuardConfig(ctx context.Context, guard string) (*hubv1.GuardConfig, hubv1.Config, error) {
+	if !Initialized() {
+		return nil, hubv1.Config_CONFIG_UNSPECIFIED, ErrNotInitialized
+	}
 	gs.guardConfigLock.RLock()
 	gc, ok := gs.guardConfig[guard]
 	gs.guardConfigLock.RUnlock()
@@ -200,6 +345,23 @@ func GetGuardConfig(ctx context.Context, guard string) (*hubv1.GuardConfig, hubv
 	return fetchGuardConfig(ctx, guard)
 }
 
+// GuardConfigGeneration changes whenever any cached guard config does, it is
+// cheap enough to check on every request
+func GuardConfigGeneration() uint64 {
+	return guardConfigGeneration.Load()
+}
+
+// CachedGuardConfig returns the cached guard config and its version, without
+// fetching it from Stanza Hub (nil if it hasn't been fetched yet)
+func CachedGuardConfig(guard string) (*hubv1.GuardConfig, string) {
+	if !Initialized() {
+		return nil, ""
+	}
+	gs.guardConfigLock.RLock()
+	defer gs.guardConfigLock.RUnlock()
+	return gs.guardConfig[guard], gs.guardConfigVersion[guard]
+}
+
 func QuotaServiceClient() hubv1grpc.QuotaServiceClient {
 	gsLock.RLock()
 	defer gsLock.RUnlock()
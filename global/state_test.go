@@ -0,0 +1,29 @@
+package global
+
+import (
+	"context"
+	"testing"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterGuardBeforeInit(t *testing.T) {
+	assert.False(t, Initialized())
+	RegisterGuard("queued")
+	pendingGuardsLock.Lock()
+	defer pendingGuardsLock.Unlock()
+	assert.Contains(t, pendingGuards, "queued")
+}
+
+func TestGuardConfigBeforeInit(t *testing.T) {
+	assert.False(t, Initialized())
+	gc, status, err := GetGuardConfig(context.Background(), "early")
+	assert.Nil(t, gc)
+	assert.Equal(t, hubv1.Config_CONFIG_UNSPECIFIED, status)
+	assert.ErrorIs(t, err, ErrNotInitialized)
+
+	gc, version := CachedGuardConfig("early")
+	assert.Nil(t, gc)
+	assert.Empty(t, version)
+}
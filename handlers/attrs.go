@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Most attribute sets a handler caches, features come from request baggage so
+// there is no telling how many combinations we'll see. Past this, attributes
+// are built for every request (like they would be without the cache).
+const MAX_ATTR_SETS = 1024
+
+// attrKey identifies one combination of guard attributes
+type attrKey struct {
+	customer string
+	feature  string
+	boost    int32
+	shadow   bool
+
+	// the outcome (only End metrics have one, see EndWith)
+	code       int
+	errorClass string
+
+	// the config, local, token, quota and custom check reasons (End metrics
+	// don't have any)
+	reasons bool
+	config  string
+	local   string
+	band    string
+	token   string
+	quota   string
+	checks  string
+	mode    string
+}
+
+// attrSet is a precomputed attribute set, along with metric options using it
+type attrSet struct {
+	set    attribute.Set
+	add    []metric.AddOption
+	record []metric.RecordOption
+}
+
+func newAttrSet(kvs []attribute.KeyValue) *attrSet {
+	set := attribute.NewSet(kvs...)
+	opt := metric.WithAttributeSet(set)
+	return &attrSet{
+		set:    set,
+		add:    []metric.AddOption{opt},
+		record: []metric.RecordOption{opt},
+	}
+}
+
+var (
+	// attribute caches, shared by every handler with the same guard, global
+	// attributes, and custom checks (helpers like stanza.Guard create a handler
+	// per call, see sharedAttrCache)
+	sharedAttrCaches     = map[string]*attrCache{}
+	sharedAttrCachesLock = &sync.Mutex{}
+)
+
+// attrCache holds the attribute sets of every guard created by the handlers
+// of a guard
+type attrCache struct {
+	lock sync.RWMutex
+	sets map[attrKey]*attrSet
+}
+
+func (c *attrCache) get(key attrKey) *attrSet {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.sets[key]
+}
+
+func (c *attrCache) put(key attrKey, as *attrSet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.sets == nil {
+		c.sets = make(map[attrKey]*attrSet)
+	}
+	if len(c.sets) < MAX_ATTR_SETS {
+		c.sets[key] = as
+	}
+}
+
+// sharedAttrCache returns the attribute cache for a guard, creating it if
+// there isn't one yet
+func sharedAttrCache(guard string, attr []attribute.KeyValue, checks []checkStatus) *attrCache {
+	var b strings.Builder
+	b.WriteString(guard)
+	for _, kv := range attr {
+		b.WriteByte(0)
+		b.WriteString(kv.Value.Emit())
+	}
+	for _, cs := range checks {
+		b.WriteByte(0)
+		b.WriteString(cs.name)
+	}
+	key := b.String()
+
+	sharedAttrCachesLock.Lock()
+	defer sharedAttrCachesLock.Unlock()
+	c, ok := sharedAttrCaches[key]
+	if !ok {
+		c = &attrCache{}
+		sharedAttrCaches[key] = c
+	}
+	return c
+}
+
+// attrs returns the guard's attribute set, with or without its reasons
+func (g *Guard) attrs(reasons bool) *attrSet {
+	return g.cachedAttrs(g.attrKey(reasons), nil)
+}
+
+func (g *Guard) attrKey(reasons bool) attrKey {
+	key := attrKey{
+		customer: g.customer,
+		feature:  g.FeatureName(),
+		boost:    g.tlr.GetPriorityBoost(),
+		shadow:   g.shadow,
+	}
+	if reasons {
+		key.reasons = true
+		key.config = g.configReason()
+		key.local = g.localReason()
+		key.band = g.localShed
+		key.token = g.tokenReason()
+		key.quota = g.quotaReason()
+		key.checks = g.checkReasons()
+		key.mode = g.mode()
+	}
+	return key
+}
+
+// cachedAttrs returns the attribute set for key, sets with extra attributes
+// aren't cached (there is no telling how many of them there are)
+func (g *Guard) cachedAttrs(key attrKey, extra []attribute.KeyValue) *attrSet {
+	if g.sets == nil || len(extra) > 0 {
+		return newAttrSet(append(g.keyValues(key), extra...))
+	}
+	if as := g.sets.get(key); as != nil {
+		return as
+	}
+	as := newAttrSet(g.keyValues(key))
+	g.sets.put(key, as)
+	return as
+}
+
+func (g *Guard) keyValues(key attrKey) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(g.attr)+14+len(g.checks))
+	kvs = append(kvs, g.attr...)
+	kvs = append(kvs,
+		guardKey.String(g.GuardName()),
+		featureKey.String(key.feature),
+		priorityBoostKey.Int64(int64(key.boost)),
+		customerIdKey.String(key.customer),
+	)
+	if key.shadow {
+		kvs = append(kvs, shadowKey.Bool(true))
+	}
+	if key.code != 0 {
+		kvs = append(kvs, statusCodeKey.Int(key.code))
+	}
+	if key.errorClass != "" {
+		kvs = append(kvs, errorClassKey.String(key.errorClass))
+	}
+	if !key.reasons {
+		return kvs
+	}
+	kvs = append(kvs,
+		configReasonKey.String(key.config),
+		localReasonKey.String(key.local),
+		tokenReasonKey.String(key.token),
+		quotaReasonKey.String(key.quota),
+	)
+	if key.band != "" {
+		kvs = append(kvs, priorityBandKey.String(key.band))
+	}
+	for _, cs := range g.checks {
+		kvs = append(kvs, attribute.Key(cs.name+"_reason").String(cs.reason))
+	}
+	if key.mode != "" {
+		kvs = append(kvs, modeKey.String(key.mode))
+	}
+	return kvs
+}
+
+// checkReasons returns the custom check reasons as a single (cache key) string,
+// the checks themselves are the same for every guard of a handler
+func (g *Guard) checkReasons() string {
+	switch len(g.checks) {
+	case 0:
+		return ""
+	case 1:
+		return g.checks[0].reason // the usual case, without allocating
+	}
+	var b strings.Builder
+	for _, cs := range g.checks {
+		b.WriteString(cs.reason)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func (g *Guard) mode() string {
+	if g.config == nil {
+		return ""
+	}
+	if g.config.ReportOnly {
+		return hubv1.Mode_MODE_REPORT_ONLY.String()
+	}
+	return hubv1.Mode_MODE_NORMAL.String()
+}
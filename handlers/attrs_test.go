@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestGuardAttrs(t *testing.T) {
+	h := &Handler{guardName: "attrs-guard", attr: make([]attribute.KeyValue, 1, 8), attrSets: &attrCache{}}
+	h.attr[0] = serviceKey.String("svc")
+
+	// extra attributes are copied, never appended to the shared h.attr
+	a := h.NewGuard(context.Background(), nil, []attribute.KeyValue{errorKey.String("a")}, nil)
+	b := h.NewGuard(context.Background(), nil, []attribute.KeyValue{errorKey.String("b")}, nil)
+	av, _ := a.attrs(false).set.Value(errorKey)
+	bv, _ := b.attrs(false).set.Value(errorKey)
+	assert.Equal(t, "a", av.AsString())
+	assert.Equal(t, "b", bv.AsString())
+	assert.Len(t, h.attr, 1)
+
+	// guards with the same attributes share a cached set
+	c := h.NewGuard(context.Background(), nil, nil, nil)
+	d := h.NewGuard(context.Background(), nil, nil, nil)
+	assert.Same(t, c.attrs(true), d.attrs(true))
+	assert.NotSame(t, c.attrs(true), c.attrs(false))
+	reason, _ := c.attrs(true).set.Value(configReasonKey)
+	assert.Equal(t, "CONFIG_NOT_FOUND", reason.AsString())
+}
+
+func TestSharedAttrCache(t *testing.T) {
+	// handlers for the same guard share attribute sets, even when created per call
+	a, _ := NewHandlerWithOptions("shared-attrs-guard", Options{})
+	b, _ := NewHandlerWithOptions("shared-attrs-guard", Options{})
+	other, _ := NewHandlerWithOptions("other-attrs-guard", Options{})
+	assert.Same(t, a.attrSets, b.attrSets)
+	assert.NotSame(t, a.attrSets, other.attrSets)
+	ga := a.NewGuard(context.Background(), nil, nil, nil)
+	gb := b.NewGuard(context.Background(), nil, nil, nil)
+	assert.Same(t, ga.attrs(true), gb.attrs(true))
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/global"
+	"github.com/StanzaSystems/sdk-go/logging"
+)
+
+// Decision is the outcome of a Check
+type Decision int
+
+const (
+	CheckAllow    Decision = iota // continue with the next check
+	CheckBlock                    // block the request
+	CheckFailOpen                 // stop checking and allow the request
+)
+
+// Check is one admission stage of a guard's pipeline. Besides the built-in
+// ConfigCheck, LocalCheck, TokenCheck, and QuotaCheck, applications can add
+// their own (per-tenant allowlists, feature flags, maintenance mode, etc).
+type Check interface {
+	// Name identifies the stage, it is reported as the "<name>_reason"
+	// attribute and as the FailOpenError stage
+	Name() string
+
+	// Check decides whether the request may proceed, g describes the request
+	// (GuardName, FeatureName, Context, etc)
+	Check(ctx context.Context, g *Guard) CheckResult
+}
+
+// CheckResult is returned by a Check
+type CheckResult struct {
+	Decision Decision
+	Reason   string        // reported reason (default: "<NAME>_ALLOWED", "<NAME>_BLOCKED", or "<NAME>_FAIL_OPEN")
+	Message  string        // block message (see Guard.BlockMessage)
+	Err      error         // why the check failed open (if it did)
+	Retry    time.Duration // suggested retry delay when blocked (0 for hub.DEFAULT_RETRY_AFTER)
+}
+
+// CheckFunc adapts a function to a Check
+func CheckFunc(name string, fn func(ctx context.Context, g *Guard) CheckResult) Check {
+	return &funcCheck{name: name, fn: fn}
+}
+
+type funcCheck struct {
+	name string
+	fn   func(ctx context.Context, g *Guard) CheckResult
+}
+
+func (c *funcCheck) Name() string {
+	return c.name
+}
+
+func (c *funcCheck) Check(ctx context.Context, g *Guard) CheckResult {
+	return c.fn(ctx, g)
+}
+
+// builtinCheck stages record their own status, metrics, spans and logs
+type builtinCheck struct {
+	funcCheck
+}
+
+// Built-in checks, in their default order. ConfigCheck must run before the
+// others, without a guard config the token and quota checks are disabled.
+var (
+	ConfigCheck Check = &builtinCheck{funcCheck{StageConfig, func(ctx context.Context, g *Guard) CheckResult {
+		if _, err := g.getGuardConfig(ctx, g.GuardName()); err != nil || g.config == nil {
+			return CheckResult{Decision: CheckFailOpen}
+		}
+		return CheckResult{Decision: CheckAllow}
+	}}}
+
+	LocalCheck Check = &builtinCheck{funcCheck{StageLocal, func(ctx context.Context, g *Guard) CheckResult {
+		if g.localStatus != hubv1.Local_LOCAL_BLOCKED { // unless a concurrency limit already blocked
+			g.checkLocal(ctx, g.GuardName(), global.SentinelEnabled())
+		}
+		if g.localStatus == hubv1.Local_LOCAL_BLOCKED {
+			return CheckResult{Decision: CheckBlock}
+		}
+		return CheckResult{Decision: CheckAllow}
+	}}}
+
+	TokenCheck Check = &builtinCheck{funcCheck{StageToken, func(ctx context.Context, g *Guard) CheckResult {
+		if err := g.checkToken(ctx, g.GuardName(), g.tokens, g.config.GetValidateIngressTokens()); err != nil {
+			return CheckResult{Decision: CheckFailOpen}
+		}
+		if g.tokenStatus == hubv1.Token_TOKEN_NOT_VALID {
+			return CheckResult{Decision: CheckBlock}
+		}
+		return CheckResult{Decision: CheckAllow}
+	}}}
+
+	QuotaCheck Check = &builtinCheck{funcCheck{StageQuota, func(ctx context.Context, g *Guard) CheckResult {
+		if err := g.checkQuota(ctx, g.tlr, g.config.GetCheckQuota()); err != nil {
+			return CheckResult{Decision: CheckFailOpen}
+		}
+		if g.quotaStatus == hubv1.Quota_QUOTA_BLOCKED {
+			return CheckResult{Decision: CheckBlock}
+		}
+		return CheckResult{Decision: CheckAllow}
+	}}}
+)
+
+// DefaultChecks returns the built-in guard pipeline
+func DefaultChecks() []Check {
+	return []Check{ConfigCheck, LocalCheck, TokenCheck, QuotaCheck}
+}
+
+// ValidateChecks returns a guard pipeline as it will run, or an error if it is
+// invalid: every check needs a name of its own (custom checks can't use a
+// built-in stage name like "config" or "quota"), and TokenCheck and QuotaCheck
+// need ConfigCheck. ConfigCheck is moved to the front, the other checks depend
+// on the guard config it fetches.
+func ValidateChecks(checks []Check) ([]Check, error) {
+	names := map[string]bool{}
+	hasConfig, needsConfig := false, false
+	for _, c := range checks {
+		if c == nil || c.Name() == "" {
+			return nil, errors.New("check must not be nil and must have a name")
+		}
+		name := strings.ToLower(c.Name())
+		if _, ok := c.(*builtinCheck); !ok && slices.Contains(stages, name) {
+			return nil, fmt.Errorf("check name %q is reserved for a built-in stage", c.Name())
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate check name %q", c.Name())
+		}
+		names[name] = true
+		hasConfig = hasConfig || c == ConfigCheck
+		needsConfig = needsConfig || c == TokenCheck || c == QuotaCheck
+	}
+	if needsConfig && !hasConfig {
+		return nil, errors.New("TokenCheck and QuotaCheck need ConfigCheck")
+	}
+
+	pipeline := make([]Check, 0, len(checks))
+	if hasConfig {
+		pipeline = append(pipeline, ConfigCheck)
+	}
+	for _, c := range checks {
+		if c != ConfigCheck {
+			pipeline = append(pipeline, c)
+		}
+	}
+	return pipeline, nil
+}
+
+// names of the built-in stages, reserved for the built-in checks
+var stages = []string{StageInit, StageConfig, StageLocal, StageToken, StageQuota}
+
+// checkStatus is the outcome of a custom check
+type checkStatus struct {
+	name    string
+	reason  string
+	blocked bool
+	message string
+	retry   time.Duration
+
+	defaults *checkDefaults
+}
+
+// checkDefaults are the reasons and message used when a CheckResult doesn't
+// have its own, built once per handler rather than for every request
+type checkDefaults struct {
+	allowed  string
+	blocked  string
+	failOpen string
+	message  string
+}
+
+// customChecks returns the initial (not evaluated) status of every custom
+// check in the pipeline, so they are always reported
+func customChecks(checks []Check) []checkStatus {
+	var cs []checkStatus
+	for _, c := range checks {
+		if _, ok := c.(*builtinCheck); !ok {
+			cs = append(cs, checkStatus{
+				name:   c.Name(),
+				reason: checkReason(c.Name(), "NOT_EVAL"),
+				defaults: &checkDefaults{
+					allowed:  checkReason(c.Name(), "ALLOWED"),
+					blocked:  checkReason(c.Name(), "BLOCKED"),
+					failOpen: checkReason(c.Name(), "FAIL_OPEN"),
+					message:  fmt.Sprintf("Blocked by %s check.", c.Name()),
+				},
+			})
+		}
+	}
+	return cs
+}
+
+func checkReason(name, status string) string {
+	return strings.ToUpper(name) + "_" + status
+}
+
+// runChecks runs the guard pipeline, returning false if it stopped early
+// (blocked, unless in shadow mode, or failed open)
+func (g *Guard) runChecks(ctx context.Context, checks []Check) bool {
+	custom := 0
+	for _, c := range checks {
+		var res CheckResult
+		if _, ok := c.(*builtinCheck); ok {
+			res = c.Check(ctx, g)
+		} else {
+			res = g.runCheck(ctx, c)
+			g.recordCheck(ctx, &g.checks[custom], res)
+			custom += 1
+		}
+		switch res.Decision {
+		case CheckBlock:
+			if !g.shadow {
+				return false
+			}
+		case CheckFailOpen:
+			return false
+		}
+	}
+	return true
+}
+
+// runCheck runs a custom check, failing open if it panics
+func (g *Guard) runCheck(ctx context.Context, c Check) (res CheckResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("check %q panicked: %v", c.Name(), r)
+			logging.Error(err, "guard", g.GuardName(), "stack", string(debug.Stack()))
+			res = CheckResult{Decision: CheckFailOpen, Err: err}
+		}
+	}()
+	return c.Check(ctx, g)
+}
+
+func (g *Guard) recordCheck(ctx context.Context, cs *checkStatus, res CheckResult) {
+	cs.reason = res.Reason
+	switch res.Decision {
+	case CheckBlock:
+		if cs.reason == "" {
+			cs.reason = cs.defaults.blocked
+		}
+		cs.blocked = true
+		cs.message = res.Message
+		if cs.message == "" {
+			cs.message = cs.defaults.message
+		}
+		cs.retry = res.Retry
+		g.blocked(ctx)
+	case CheckFailOpen:
+		if cs.reason == "" {
+			cs.reason = cs.defaults.failOpen
+		}
+		if res.Err != nil {
+			g.err = failOpenError(cs.name, res.Err)
+			g.failopen(ctx, res.Err)
+		}
+	default:
+		if cs.reason == "" {
+			cs.reason = cs.defaults.allowed
+		}
+	}
+}
+
+// blockedCheck returns the first custom check which blocked (if any)
+func (g *Guard) blockedCheck() *checkStatus {
+	for i := range g.checks {
+		if g.checks[i].blocked {
+			return &g.checks[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateChecks(t *testing.T) {
+	allow := func(name string) Check {
+		return CheckFunc(name, func(context.Context, *Guard) CheckResult { return CheckResult{} })
+	}
+	pipeline, err := ValidateChecks([]Check{LocalCheck, allow("tenant"), ConfigCheck, QuotaCheck})
+	assert.NoError(t, err)
+	assert.Equal(t, []Check{ConfigCheck, LocalCheck, pipeline[2], QuotaCheck}, pipeline, "ConfigCheck is pinned first")
+
+	for name, checks := range map[string][]Check{
+		"nil check":            {nil},
+		"unnamed check":        {allow("")},
+		"duplicate names":      {allow("tenant"), allow("TENANT")},
+		"built-in stage name":  {allow("quota")},
+		"duplicate built-in":   {ConfigCheck, ConfigCheck},
+		"quota without config": {QuotaCheck},
+	} {
+		_, err := ValidateChecks(checks)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestCheckPipeline(t *testing.T) {
+	if _, err := hubtest.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var ran []string
+	check := func(name string, res CheckResult) Check {
+		return CheckFunc(name, func(context.Context, *Guard) CheckResult {
+			ran = append(ran, name)
+			return res
+		})
+	}
+	blocker := check("maintenance", CheckResult{Decision: CheckBlock, Message: "Down for maintenance."})
+
+	// checks run in order, until one blocks
+	h, err := NewHandlerWithOptions("pipeline-guard", Options{Checks: []Check{
+		check("first", CheckResult{}), blocker, check("last", CheckResult{}),
+	}})
+	assert.NoError(t, err)
+	g := h.Guard(ctx, nil, nil)
+	assert.Equal(t, []string{"first", "maintenance"}, ran)
+	assert.True(t, g.Blocked())
+	assert.Equal(t, "MAINTENANCE_BLOCKED", g.BlockReason())
+	assert.Equal(t, "Down for maintenance.", g.BlockMessage())
+	var cbe *CheckBlockedError
+	assert.True(t, errors.As(g.BlockError(), &cbe))
+	assert.Equal(t, "maintenance", cbe.Stage)
+	assert.True(t, errors.Is(g.BlockError(), ErrCheckBlocked))
+	assert.Equal(t, []string{"FIRST_ALLOWED", "MAINTENANCE_BLOCKED", "LAST_NOT_EVAL"},
+		[]string{g.checks[0].reason, g.checks[1].reason, g.checks[2].reason})
+
+	// shadow mode runs every check
+	ran = nil
+	h, _ = NewHandlerWithOptions("pipeline-guard", Options{Shadow: true, Checks: []Check{
+		blocker, check("last", CheckResult{Reason: "CUSTOM"}),
+	}})
+	g = h.Guard(ctx, nil, nil)
+	assert.Equal(t, []string{"maintenance", "last"}, ran)
+	assert.True(t, g.Allowed())
+	assert.True(t, g.WouldBlock())
+	assert.Equal(t, "CUSTOM", g.checks[1].reason)
+	g.End(g.Success)
+
+	// a panicking check fails open
+	h, _ = NewHandlerWithOptions("pipeline-guard", Options{Checks: []Check{
+		CheckFunc("broken", func(context.Context, *Guard) CheckResult { panic("boom") }),
+	}})
+	g = h.Guard(ctx, nil, nil)
+	assert.True(t, g.Allowed())
+	var foe *FailOpenError
+	assert.True(t, errors.As(g.Error(), &foe))
+	assert.Equal(t, "broken", foe.Stage)
+	assert.Equal(t, "BROKEN_FAIL_OPEN", g.checks[0].reason)
+	g.End(g.Success)
+}
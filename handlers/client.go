@@ -8,3 +8,8 @@ func NewOutboundHandler(gn string, fn *string, pb *int32, dw *float32, kv *map[s
 	h, err := NewHandler(gn, fn, pb, dw, kv)
 	return &OutboundHandler{h}, err
 }
+
+func NewOutboundHandlerWithOptions(gn string, o Options) (*OutboundHandler, error) {
+	h, err := NewHandlerWithOptions(gn, o)
+	return &OutboundHandler{h}, err
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/global"
+	"github.com/StanzaSystems/sdk-go/limiter"
+	"github.com/StanzaSystems/sdk-go/logging"
+
+	"github.com/alibaba/sentinel-golang/core/system_metric"
+)
+
+const (
+	// How long a guard may hold a concurrency slot before we assume End is never
+	// going to be called, report it, and free the slot
+	DEFAULT_LEAK_TIMEOUT = 5 * time.Minute
+	LEAK_SWEEP_INTERVAL  = 1 * time.Second // how often watched guards are checked for leaks
+)
+
+var (
+	// concurrency limiters, keyed by guard (and feature) name
+	sharedLimiters     = map[string]limiter.Limiter{}
+	sharedLimitersLock = &sync.Mutex{}
+
+	// guards holding a concurrency slot or Sentinel entry, with their deadlines
+	// (checked by a single leakSweeper rather than a timer per guard)
+	watchedGuards     = map[*Guard]time.Time{}
+	watchedGuardsLock = &sync.Mutex{}
+	leakSweeperOnce   sync.Once
+)
+
+// sharedLimiter returns the limiter for a guard (and feature), creating it with
+// newLimiter if there isn't one yet. Every handler for the same guard shares
+// one in-flight count (helpers like stanza.Do create a handler per call), the
+// first handler's settings win.
+func sharedLimiter(guard, feature string, newLimiter func() limiter.Limiter) limiter.Limiter {
+	key := guard
+	if feature != "" {
+		key = guard + "\x00" + feature
+	}
+	sharedLimitersLock.Lock()
+	defer sharedLimitersLock.Unlock()
+	l, ok := sharedLimiters[key]
+	if !ok {
+		l = newLimiter()
+		sharedLimiters[key] = l
+	}
+	return l
+}
+
+// limitersFor returns the concurrency limiters which apply to a feature (its
+// own limit first, then the guard wide one)
+func (h *Handler) limitersFor(feature string) []limiter.Limiter {
+	if h.limiter == nil && len(h.featureLimiters) == 0 {
+		return nil
+	}
+	ls := make([]limiter.Limiter, 0, 2)
+	if l, ok := h.featureLimiters[feature]; ok {
+		ls = append(ls, l)
+	}
+	if h.limiter != nil {
+		ls = append(ls, h.limiter)
+	}
+	return ls
+}
+
+// acquire reserves an in-flight slot from every limiter, returning false (and
+// marking the guard blocked) if any of them is at its limit
+func (g *Guard) acquire(ctx context.Context, ls []limiter.Limiter, leakTimeout time.Duration) bool {
+	for i, l := range ls {
+		if !l.Acquire() {
+			for _, held := range ls[:i] {
+				held.Release(0, false)
+			}
+			g.localStatus = hubv1.Local_LOCAL_BLOCKED
+			g.localLimit = l.Limit()
+			g.blocked(ctx)
+			return false
+		}
+	}
+	if len(ls) > 0 {
+		g.limiters = ls
+		g.acquired = time.Now()
+		g.watchLeak(leakTimeout)
+	}
+	return true
+}
+
+// wouldAcquire marks a shadow guard blocked if any limiter is at its limit,
+// without reserving a slot
+func (g *Guard) wouldAcquire(ctx context.Context, ls []limiter.Limiter) {
+	if l := shadowLimit(ls); l != nil {
+		g.localStatus = hubv1.Local_LOCAL_BLOCKED
+		g.localLimit = l.Limit()
+		g.blocked(ctx)
+	}
+}
+
+// watchLeak frees the guard's in-flight slots and Sentinel entry if it isn't
+// ended within leakTimeout
+func (g *Guard) watchLeak(leakTimeout time.Duration) {
+	if leakTimeout <= 0 {
+		leakTimeout = DEFAULT_LEAK_TIMEOUT
+	}
+	leakSweeperOnce.Do(func() { go leakSweeper() })
+	watchedGuardsLock.Lock()
+	watchedGuards[g] = time.Now().Add(leakTimeout)
+	watchedGuardsLock.Unlock()
+	g.watched = true
+}
+
+// unwatchLeak stops watching an ended (or blocked) guard
+func (g *Guard) unwatchLeak() {
+	if !g.watched {
+		return
+	}
+	watchedGuardsLock.Lock()
+	delete(watchedGuards, g)
+	watchedGuardsLock.Unlock()
+}
+
+func leakSweeper() {
+	for {
+		select {
+		case <-global.Done():
+			return
+		case now := <-time.After(LEAK_SWEEP_INTERVAL):
+			sweepLeaks(now)
+		}
+	}
+}
+
+// sweepLeaks frees the slots and Sentinel entries of guards past their deadline
+func sweepLeaks(now time.Time) {
+	var leaked []*Guard
+	watchedGuardsLock.Lock()
+	for g, deadline := range watchedGuards {
+		if now.After(deadline) {
+			leaked = append(leaked, g)
+			delete(watchedGuards, g)
+		}
+	}
+	watchedGuardsLock.Unlock()
+	for _, g := range leaked {
+		logging.Error(
+			errors.New("guard not ended within its leak timeout, releasing its concurrency slot and Sentinel entry"),
+			g.logAttr(nil)...)
+		g.release(0, true)
+		g.exitLocal(nil)
+	}
+}
+
+// release frees the guard's in-flight slots (only the first call has any effect)
+func (g *Guard) release(rtt time.Duration, dropped bool) {
+	if len(g.limiters) == 0 || !g.released.CompareAndSwap(false, true) {
+		return
+	}
+	for _, l := range g.limiters {
+		l.Release(rtt, dropped)
+	}
+}
+
+// priority returns the local priority of a request, its feature's base
+// priority plus its priority boost
+func (h *Handler) priority(feature string, boost int32) int32 {
+	if p, ok := h.featurePriorities[feature]; ok {
+		return p + boost
+	}
+	return limiter.PriorityNormal + boost
+}
+
+// shed marks the guard blocked because its priority band was shed
+func (g *Guard) shed(ctx context.Context, band int) {
+	g.localStatus = hubv1.Local_LOCAL_BLOCKED
+	g.localShed = limiter.Bands[band]
+	g.blocked(ctx)
+}
+
+// cpuUsage returns process CPU usage (0-1 across all cores) as collected by
+// Sentinel, or a negative value if it hasn't been collected yet
+func cpuUsage() float64 {
+	pct := system_metric.CurrentCpuUsage() // percent of a single core
+	if pct < 0 {
+		return pct
+	}
+	return pct / 100 / float64(runtime.NumCPU())
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/StanzaSystems/sdk-go/limiter"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/alibaba/sentinel-golang/api"
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
+	"github.com/alibaba/sentinel-golang/core/flow"
+)
+
+func TestPriorityShedding(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	guards := func(h *Handler, n int) []*Guard {
+		gs := []*Guard{}
+		for i := 0; i < n; i++ {
+			gs = append(gs, h.Guard(ctx, nil, nil))
+		}
+		t.Cleanup(func() {
+			for _, g := range gs {
+				g.End(g.Success)
+			}
+		})
+		return gs
+	}
+
+	// without shedding, normal priority traffic gets the whole limit
+	hub.SetGuardConfig("limited-guard", &hubv1.GuardConfig{})
+	h, _ := NewHandlerWithOptions("limited-guard", Options{ConcurrencyLimit: 10})
+	assert.Nil(t, h.shedder)
+	for _, g := range guards(h, 10) {
+		assert.True(t, g.Allowed())
+	}
+	g := guards(h, 1)[0]
+	assert.True(t, errors.Is(g.BlockError(), ErrConcurrencyLimited))
+
+	// with shedding, it gets its band's share and critical traffic the rest
+	hub.SetGuardConfig("shedding-guard", &hubv1.GuardConfig{})
+	critical := "critical"
+	o := Options{
+		ConcurrencyLimit:  10,
+		PriorityShedding:  true,
+		FeaturePriorities: map[string]int32{critical: limiter.PriorityCritical},
+	}
+	h, _ = NewHandlerWithOptions("shedding-guard", o)
+	for _, g := range guards(h, 9) {
+		assert.True(t, g.Allowed())
+	}
+	g = guards(h, 1)[0]
+	assert.True(t, errors.Is(g.BlockError(), ErrPriorityShed))
+
+	o.Feature = &critical
+	h, _ = NewHandlerWithOptions("shedding-guard", o)
+	assert.True(t, guards(h, 1)[0].Allowed())
+}
+
+func TestLeakSweeper(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetGuardConfig("leaky-guard", &hubv1.GuardConfig{})
+	h, _ := NewHandlerWithOptions("leaky-guard", Options{ConcurrencyLimit: 1, LeakTimeout: time.Minute})
+	ctx := context.Background()
+
+	leaked := h.Guard(ctx, nil, nil)
+	assert.True(t, leaked.Allowed())
+	assert.False(t, h.Guard(ctx, nil, nil).Allowed())
+
+	// not leaked yet, then its slot is freed once past the leak timeout
+	sweepLeaks(time.Now())
+	assert.False(t, h.Guard(ctx, nil, nil).Allowed())
+	sweepLeaks(time.Now().Add(time.Minute + time.Second))
+	g := h.Guard(ctx, nil, nil)
+	assert.True(t, g.Allowed())
+
+	// ended guards stop being watched
+	g.End(g.Success)
+	watchedGuardsLock.Lock()
+	_, ok := watchedGuards[g]
+	watchedGuardsLock.Unlock()
+	assert.False(t, ok)
+	leaked.End(leaked.Success)
+}
+
+func TestShadowCapacity(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetGuardConfig("shadowed-guard", &hubv1.GuardConfig{})
+	h, _ := NewHandlerWithOptions("shadowed-guard", Options{ConcurrencyLimit: 1})
+	ctx := context.Background()
+	shadow := ContextWithShadow(ctx)
+
+	// shadow guards don't hold slots, so enforced traffic keeps its capacity
+	for i := 0; i < 3; i++ {
+		g := h.Guard(shadow, nil, nil)
+		assert.True(t, g.Allowed())
+		assert.False(t, g.WouldBlock())
+		defer g.End(g.Success)
+	}
+	g := h.Guard(ctx, nil, nil)
+	assert.True(t, g.Allowed())
+
+	// but would block once it's used up
+	sg := h.Guard(shadow, nil, nil)
+	assert.True(t, sg.Allowed())
+	assert.True(t, sg.WouldBlock())
+	sg.End(sg.Success)
+	g.End(g.Success)
+	assert.Equal(t, 0, h.limiter.InFlight())
+}
+
+func TestShadowLocal(t *testing.T) {
+	resource := "shadow-local-guard"
+	_, err := flow.LoadRulesOfResource(resource, []*flow.Rule{{
+		Resource: resource, Threshold: 1, StatIntervalInMs: 1000,
+		TokenCalculateStrategy: flow.Direct, ControlBehavior: flow.Reject,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flow.ClearRulesOfResource(resource) })
+	assert.Nil(t, shadowLocal(resource))
+
+	// the resource's only pass this second was enforced, a shadow guard
+	// would be blocked (without passing itself)
+	e, b := api.Entry(resource, api.WithTrafficType(base.Inbound))
+	assert.Nil(t, b)
+	e.Exit()
+	b = shadowLocal(resource)
+	if assert.NotNil(t, b) {
+		assert.Equal(t, base.BlockTypeFlow, b.BlockType())
+	}
+	b = shadowLocal(resource)
+	assert.NotNil(t, b)
+
+	// as would one for an open circuit breaker
+	rule := &circuitbreaker.Rule{Resource: "shadow-breaker", Strategy: circuitbreaker.ErrorCount, RetryTimeoutMs: 1000, StatIntervalMs: 1000, Threshold: 1}
+	_, err = circuitbreaker.LoadRulesOfResource(rule.Resource, []*circuitbreaker.Rule{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { circuitbreaker.ClearRulesOfResource(rule.Resource) })
+	assert.Nil(t, shadowLocal(rule.Resource))
+	setBreakerOpen(rule, true)
+	assert.NotNil(t, shadowLocal(rule.Resource))
+	setBreakerOpen(rule, false)
+	assert.Nil(t, shadowLocal(rule.Resource))
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+
+	"github.com/alibaba/sentinel-golang/core/base"
+)
+
+// Block reasons, as returned by Guard.BlockReason
+var (
+	ReasonLocalBlocked  = hubv1.Local_LOCAL_BLOCKED.String()
+	ReasonTokenNotValid = hubv1.Token_TOKEN_NOT_VALID.String()
+	ReasonQuotaBlocked  = hubv1.Quota_QUOTA_BLOCKED.String()
+)
+
+// Guard stages, as reported by FailOpenError
+const (
+	StageInit   = "init"
+	StageConfig = "config"
+	StageLocal  = "local"
+	StageToken  = "token"
+	StageQuota  = "quota"
+)
+
+var (
+	// ErrBlocked is matched (via errors.Is) by every error returned for a
+	// request which was blocked by a guard.
+	ErrBlocked = errors.New("blocked by stanza guard")
+
+	// ErrQuotaExhausted is matched when Stanza Hub had no quota for the request.
+	ErrQuotaExhausted = errors.New("stanza quota exhausted")
+
+	// ErrLocalBlocked is matched when a local (Sentinel) rule blocked the request.
+	ErrLocalBlocked = errors.New("blocked by local stanza rule")
+
+	// ErrInvalidToken is matched when ingress token validation failed.
+	ErrInvalidToken = errors.New("invalid or expired stanza token")
+
+	// ErrConcurrencyLimited is matched when an in-process concurrency limit
+	// blocked the request (it also matches ErrLocalBlocked).
+	ErrConcurrencyLimited = errors.New("stanza concurrency limit reached")
+
+	// ErrPriorityShed is matched when the request's priority band was shed
+	// under local overload (it also matches ErrLocalBlocked).
+	ErrPriorityShed = errors.New("stanza shed low priority traffic")
+
+	// ErrCheckBlocked is matched when a custom Check blocked the request.
+	ErrCheckBlocked = errors.New("blocked by stanza check")
+)
+
+// recorded on the Sentinel entry of guards ended with Failure but no error
+var errGuardFailure = errors.New("guarded work failed")
+
+// BlockedError is returned for requests which were blocked by a guard. It
+// matches ErrBlocked and unwraps to the specific reason (ErrQuotaExhausted,
+// *LocalBlockedError, ErrInvalidToken, or *CheckBlockedError).
+type BlockedError struct {
+	Guard   string
+	Feature string
+	Reason  string // see Guard.BlockReason
+	Message string // see Guard.BlockMessage
+	Err     error
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("stanza guard %q blocked request (%s): %s", e.Guard, e.Reason, e.Message)
+}
+
+func (e *BlockedError) Is(target error) bool {
+	return target == ErrBlocked
+}
+
+func (e *BlockedError) Unwrap() error {
+	return e.Err
+}
+
+// LocalBlockedError wraps the Sentinel BlockError for requests which were
+// blocked by a local rule, the concurrency limit which was reached, or the
+// priority band which was shed. It matches ErrLocalBlocked.
+type LocalBlockedError struct {
+	Block *base.BlockError
+	Limit int    // concurrency limit which was reached (0 for Sentinel blocks)
+	Band  string // priority band which was shed (if any)
+}
+
+func (e *LocalBlockedError) Error() string {
+	if e.Block == nil {
+		if e.Band != "" {
+			return fmt.Sprintf("%s: %s (%s)", ErrLocalBlocked, ErrPriorityShed, e.Band)
+		}
+		if e.Limit > 0 {
+			return fmt.Sprintf("%s: %s (%d)", ErrLocalBlocked, ErrConcurrencyLimited, e.Limit)
+		}
+		return ErrLocalBlocked.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrLocalBlocked, e.Block.Error())
+}
+
+func (e *LocalBlockedError) Is(target error) bool {
+	return target == ErrLocalBlocked
+}
+
+func (e *LocalBlockedError) Unwrap() error {
+	if e.Block == nil {
+		if e.Band != "" {
+			return ErrPriorityShed
+		}
+		if e.Limit > 0 {
+			return ErrConcurrencyLimited
+		}
+		return nil
+	}
+	return e.Block
+}
+
+// CheckBlockedError is returned (wrapped in a BlockedError) for requests which
+// were blocked by a custom Check. It matches ErrCheckBlocked.
+type CheckBlockedError struct {
+	Stage string // name of the Check
+}
+
+func (e *CheckBlockedError) Error() string {
+	return fmt.Sprintf("%s (%s)", ErrCheckBlocked, e.Stage)
+}
+
+func (e *CheckBlockedError) Is(target error) bool {
+	return target == ErrCheckBlocked
+}
+
+// FailOpenError is returned (by Guard.Error) when a guard stage could not be
+// evaluated and the guard failed open, allowing the request.
+type FailOpenError struct {
+	Stage string // StageConfig, StageToken, StageQuota, etc
+	Err   error  // underlying cause
+}
+
+func (e *FailOpenError) Error() string {
+	return fmt.Sprintf("stanza guard failed open (%s): %v", e.Stage, e.Err)
+}
+
+func (e *FailOpenError) Unwrap() error {
+	return e.Err
+}
+
+func failOpenError(stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var foe *FailOpenError
+	if errors.As(err, &foe) {
+		return err
+	}
+	return &FailOpenError{Stage: stage, Err: err}
+}
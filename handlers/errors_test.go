@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockedError(t *testing.T) {
+	type test struct {
+		testName string
+		err      error
+		is       []error
+		isNot    []error
+	}
+
+	tests := []test{
+		{
+			testName: "quota exhausted",
+			err:      &BlockedError{Guard: "g", Err: ErrQuotaExhausted},
+			is:       []error{ErrBlocked, ErrQuotaExhausted},
+			isNot:    []error{ErrLocalBlocked, ErrInvalidToken},
+		},
+		{
+			testName: "invalid token",
+			err:      &BlockedError{Guard: "g", Err: ErrInvalidToken},
+			is:       []error{ErrBlocked, ErrInvalidToken},
+			isNot:    []error{ErrLocalBlocked, ErrQuotaExhausted},
+		},
+		{
+			testName: "local blocked",
+			err:      &BlockedError{Guard: "g", Err: &LocalBlockedError{Block: base.NewBlockError(base.WithBlockType(base.BlockTypeFlow))}},
+			is:       []error{ErrBlocked, ErrLocalBlocked},
+			isNot:    []error{ErrInvalidToken, ErrQuotaExhausted},
+		},
+		{
+			testName: "wrapped",
+			err:      fmt.Errorf("outbound request: %w", &BlockedError{Guard: "g", Err: ErrQuotaExhausted}),
+			is:       []error{ErrBlocked, ErrQuotaExhausted},
+			isNot:    []error{ErrInvalidToken},
+		},
+	}
+
+	for _, tc := range tests {
+		for _, target := range tc.is {
+			assert.ErrorIs(t, tc.err, target, tc.testName)
+		}
+		for _, target := range tc.isNot {
+			assert.NotErrorIs(t, tc.err, target, tc.testName)
+		}
+	}
+}
+
+func TestLocalBlockedErrorAs(t *testing.T) {
+	be := base.NewBlockError(base.WithBlockType(base.BlockTypeIsolation))
+	err := error(&BlockedError{Guard: "g", Err: &LocalBlockedError{Block: be}})
+
+	var sentinelErr *base.BlockError
+	assert.True(t, errors.As(err, &sentinelErr))
+	assert.Equal(t, base.BlockTypeIsolation, sentinelErr.BlockType())
+}
+
+func TestFailOpenError(t *testing.T) {
+	cause := errors.New("hub unavailable")
+	err := failOpenError(StageQuota, cause)
+
+	var foe *FailOpenError
+	assert.True(t, errors.As(err, &foe))
+	assert.Equal(t, StageQuota, foe.Stage)
+	assert.ErrorIs(t, err, cause)
+	assert.NotErrorIs(t, err, ErrBlocked)
+
+	// already a FailOpenError, keep the original stage
+	assert.Equal(t, err, failOpenError(StageInit, err))
+	assert.Nil(t, failOpenError(StageQuota, nil))
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/global"
+	"github.com/StanzaSystems/sdk-go/logging"
+	"github.com/StanzaSystems/sdk-go/otel"
+
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
+	"github.com/alibaba/sentinel-golang/core/flow"
+	"github.com/alibaba/sentinel-golang/core/isolation"
+	"github.com/alibaba/sentinel-golang/core/system"
+)
+
+// Sentinel rules are loaded asynchronously (after the service config which
+// carries them is accepted), so the fast path decision is re-evaluated at
+// least this often even if no guard config changed
+const FAST_PATH_RECHECK_INTERVAL = time.Second
+
+// fastPath is a cached decision on whether a guard has nothing to check
+type fastPath struct {
+	generation uint64
+	expires    time.Time
+	config     *hubv1.GuardConfig // nil unless the fast path applies
+	tlr        *hubv1.GetTokenLeaseRequest
+}
+
+type fastPathCache struct {
+	enabled bool // opted in, and nothing configured locally needs the full pipeline
+	state   atomic.Pointer[fastPath]
+}
+
+// fastGuard returns an allowed guard without running the guard pipeline, or
+// nil if the guard has something to check
+func (h *Handler) fastGuard(ctx context.Context) *Guard {
+	if !h.fast.enabled || isShadow(ctx) {
+		return nil
+	}
+	fp := h.fast.state.Load()
+	if fp == nil || fp.generation != global.GuardConfigGeneration() || time.Now().After(fp.expires) {
+		fp = h.checkFastPath()
+	}
+	if fp.config == nil {
+		return nil
+	}
+
+	// Propagate Feature and PriorityBoost (from baggage or headers) as
+	// NewTokenLeaseRequest does, the cached request is shared unless they differ
+	tlr := fp.tlr
+	ctx, feat := otel.GetFeature(ctx, h.featureName)
+	ctx, boost := otel.GetPriorityBoost(ctx, h.priorityBoost)
+	if (feat != nil && *feat != tlr.GetSelector().GetFeatureName()) || boost != nil {
+		tlr = &hubv1.GetTokenLeaseRequest{
+			Selector:      &hubv1.GuardFeatureSelector{GuardName: h.guardName, FeatureName: feat},
+			PriorityBoost: boost,
+		}
+	}
+	return &Guard{
+		ctx:  ctx,
+		tlr:  tlr,
+		fast: true,
+
+		Success: GuardSuccess,
+		Failure: GuardFailure,
+		Unknown: GuardUnknown,
+
+		configStatus: hubv1.Config_CONFIG_CACHED_OK,
+		config:       fp.config,
+		localStatus:  hubv1.Local_LOCAL_EVAL_DISABLED,
+		tokenStatus:  hubv1.Token_TOKEN_EVAL_DISABLED,
+		quotaStatus:  hubv1.Quota_QUOTA_EVAL_DISABLED,
+	}
+}
+
+func (h *Handler) checkFastPath() *fastPath {
+	fp := &fastPath{
+		generation: global.GuardConfigGeneration(),
+		expires:    time.Now().Add(FAST_PATH_RECHECK_INTERVAL),
+		tlr: &hubv1.GetTokenLeaseRequest{
+			Selector: &hubv1.GuardFeatureSelector{GuardName: h.guardName, FeatureName: h.featureName},
+		},
+	}
+	gc, version := h.guardConfig(h.guardName)
+	if gc != nil && !gc.CheckQuota && !gc.ValidateIngressTokens && !sentinelRules(h.guardName) {
+		fp.config = gc
+	}
+	if prev := h.fast.state.Swap(fp); prev == nil || (prev.config == nil) != (fp.config == nil) {
+		logging.Debug("guard fast path", "guard", h.guardName, "version", version, "enabled", fp.config != nil)
+	}
+	return fp
+}
+
+// sentinelRules reports whether any Sentinel rule could block the resource
+func sentinelRules(resource string) bool {
+	if !global.SentinelEnabled() {
+		return false
+	}
+	return len(flow.GetRulesOfResource(resource)) > 0 ||
+		len(circuitbreaker.GetRulesOfResource(resource)) > 0 ||
+		len(isolation.GetRulesOfResource(resource)) > 0 ||
+		len(system.GetRules()) > 0 // system rules apply to every inbound resource
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/keys"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+func fastHandler(t testing.TB, o Options, gc *hubv1.GuardConfig) *Handler {
+	h, err := NewHandlerWithOptions("fast-path-guard", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.guardConfig = func(string) (*hubv1.GuardConfig, string) { return gc, "v1" }
+	return h
+}
+
+func TestFastPath(t *testing.T) {
+	h := fastHandler(t, Options{FastPath: true}, &hubv1.GuardConfig{})
+	g := h.Guard(context.Background(), nil, nil)
+	assert.True(t, g.fast)
+	assert.True(t, g.Allowed())
+	assert.Equal(t, "fast-path-guard", g.GuardName())
+	g.End(g.Success)
+
+	// shadow mode always runs the full pipeline
+	h = fastHandler(t, Options{FastPath: true, Shadow: true}, &hubv1.GuardConfig{})
+	assert.False(t, h.fast.enabled)
+	assert.Nil(t, h.fastGuard(ContextWithShadow(context.Background())))
+
+	// as does a config with something to check
+	h = fastHandler(t, Options{FastPath: true}, &hubv1.GuardConfig{CheckQuota: true})
+	assert.Nil(t, h.fastGuard(context.Background()))
+}
+
+func TestFastPathBaggage(t *testing.T) {
+	h := fastHandler(t, Options{FastPath: true}, &hubv1.GuardConfig{})
+	feat, _ := baggage.NewMember(keys.StzFeat, "checkout")
+	boost, _ := baggage.NewMember(keys.StzBoost, "2")
+	bag, _ := baggage.New(feat, boost)
+
+	g := h.Guard(baggage.ContextWithBaggage(context.Background(), bag), nil, nil)
+	assert.True(t, g.fast)
+	assert.Equal(t, "checkout", g.FeatureName())
+	assert.Equal(t, int32(2), g.tlr.GetPriorityBoost())
+	assert.Equal(t, "checkout", baggage.FromContext(g.Context()).Member(keys.StzFeat).Value())
+
+	// without baggage the cached request is shared
+	g = h.Guard(context.Background(), nil, nil)
+	assert.Same(t, h.fast.state.Load().tlr, g.tlr)
+}
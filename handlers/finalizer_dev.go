@@ -0,0 +1,20 @@
+//go:build stanzadev
+
+package handlers
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/StanzaSystems/sdk-go/logging"
+)
+
+// setFinalizer warns (in development builds, built with "-tags stanzadev")
+// about allowed guards which were garbage collected without being ended
+func setFinalizer(g *Guard) {
+	runtime.SetFinalizer(g, func(g *Guard) {
+		if !g.ended.Load() {
+			logging.Error(fmt.Errorf("guard garbage collected without calling End"), g.logAttr(nil)...)
+		}
+	})
+}
@@ -13,9 +13,9 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type OutboundHandler struct {
@@ -24,14 +24,26 @@ type OutboundHandler struct {
 
 // NewOutboundHandler returns a new OutboundHandler
 func NewOutboundHandler(gn string, fn *string, pb *int32, dw *float32, kv *map[string]string) (*OutboundHandler, error) {
-	h, err := handlers.NewOutboundHandler(gn, fn, pb, dw, kv)
+	return NewOutboundHandlerWithOptions(gn, handlers.Options{
+		Feature:       fn,
+		PriorityBoost: pb,
+		DefaultWeight: dw,
+		Tags:          kv,
+	})
+}
+
+// NewOutboundHandlerWithOptions returns a new OutboundHandler configured with handlers.Options
+func NewOutboundHandlerWithOptions(gn string, o handlers.Options) (*OutboundHandler, error) {
+	h, err := handlers.NewOutboundHandlerWithOptions(gn, o)
 	if err != nil {
 		return nil, err
 	}
 	return &OutboundHandler{h}, nil
 }
 
-// NewUnaryClientInterceptor returns a Guarded grpc.UnaryClientInterceptor
+// NewUnaryClientInterceptor returns a Guarded grpc.UnaryClientInterceptor. With
+// a retry policy (see handlers.RetryPolicy), failed attempts of idempotent
+// methods are retried (and hedged), each through the guard again.
 func (h *OutboundHandler) NewUnaryClientInterceptor() grpc.UnaryClientInterceptor {
 	return func(
 		ctx context.Context,
@@ -44,12 +56,39 @@ func (h *OutboundHandler) NewUnaryClientInterceptor() grpc.UnaryClientIntercepto
 		ctx, span := h.start(ctx, method)
 		defer span.End()
 
-		if guard := h.Guard(ctx, span, nil); guard.Blocked() {
-			return h.blocked(span, guard)
-		} else {
-			err := invoker(h.headers(ctx, guard.Token()), method, req, reply, cc, callOpts...)
-			return h.allowed(span, guard, err)
+		// hedged attempts run concurrently, so each needs a reply of its own
+		// (only proto replies can be cloned, others are retried but not hedged)
+		p := h.RetryPolicy()
+		idempotent := p != nil && p.Idempotent(method)
+		msg, isProto := reply.(proto.Message)
+		hedged := idempotent && p.HedgeAfter > 0 && isProto
+		try, cancel := handlers.Retry(ctx, h.Handler, idempotent, isProto,
+			func(ctx context.Context, attempt int) handlers.Try[interface{}] {
+				out := reply
+				if hedged {
+					out = msg.ProtoReflect().New().Interface()
+				}
+				guard := h.Guard(ctx, span, nil)
+				if guard.Blocked() {
+					return handlers.Try[interface{}]{Err: h.blocked(span, guard), Blocked: true}
+				}
+				err := invoker(h.headers(ctx, guard.Token()), method, req, out, cc, callOpts...)
+				return handlers.Try[interface{}]{
+					Value:     out,
+					Err:       h.allowed(span, guard, err),
+					Retryable: err != nil && p != nil && p.RetryableGrpcCode(status.Code(err)) && ctx.Err() == nil,
+				}
+			},
+			nil,
+		)
+		if cancel != nil {
+			cancel()
+		}
+		if hedged && try.Err == nil {
+			proto.Reset(msg)
+			proto.Merge(msg, try.Value.(proto.Message))
 		}
+		return try.Err
 	}
 }
 
@@ -103,6 +142,7 @@ func (h *OutboundHandler) start(ctx context.Context, spanName string) (context.C
 
 	h.Propagator().Inject(ctx, &otel.MetadataSupplier{Metadata: &md})
 	ctx = metadata.NewOutgoingContext(ctx, md)
+	ctx = h.ExtractTags(ctx, handlers.MetadataTagSource(md))
 
 	ctx, span := h.Tracer().Start(
 		ctx,
@@ -118,20 +158,18 @@ func (h *OutboundHandler) allowed(span trace.Span, guard *handlers.Guard, err er
 	s, _ := status.FromError(err)
 	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(s.Code())))
 
+	outcome := handlers.Outcome{Status: guard.Success, Err: err, Code: int(s.Code())}
 	if err != nil {
 		span.SetStatus(otel_codes.Error, s.Message())
 		span.RecordError(err)
-		guard.End(guard.Failure)
+		outcome.Status = guard.Failure
 	} else {
 		span.SetStatus(otel_codes.Ok, "OK")
-		guard.End(guard.Success)
 	}
+	guard.EndWith(outcome)
 	return err
 }
 
 func (h *OutboundHandler) blocked(span trace.Span, guard *handlers.Guard) error {
-	// TODO: codes.ResourceExhausted is wrong for failed token check
-	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(codes.ResourceExhausted)))
-	span.SetStatus(otel_codes.Error, guard.BlockMessage())
-	return status.Errorf(codes.ResourceExhausted, guard.BlockMessage())
+	return blocked(h.Handler, span, guard)
 }
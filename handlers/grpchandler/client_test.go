@@ -0,0 +1,67 @@
+package grpchandler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/stretchr/testify/assert"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestUnaryClientHedging(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetGuardConfig("hedged-client", &hubv1.GuardConfig{})
+	method := "/test.Service/Get"
+	h, err := NewOutboundHandlerWithOptions("hedged-client", handlers.Options{
+		Retry: &handlers.RetryPolicy{MaxAttempts: 3, HedgeAfter: time.Millisecond, IdempotentMethods: []string{method}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor := h.NewUnaryClientInterceptor()
+
+	// a slow call, counting its attempts and how many of them ran at once
+	type counts struct{ calls, inflight, concurrent atomic.Int32 }
+	slow := func(c *counts) grpc.UnaryInvoker {
+		return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			c.calls.Add(1)
+			if n := c.inflight.Add(1); n > c.concurrent.Load() {
+				c.concurrent.Store(n)
+			}
+			defer c.inflight.Add(-1)
+			time.Sleep(20 * time.Millisecond)
+			switch r := reply.(type) {
+			case *wrapperspb.StringValue:
+				r.Value = "ok"
+			case *[]string:
+				*r = append(*r, "ok")
+			}
+			return nil
+		}
+	}
+
+	// proto replies are cloned, so slow attempts are hedged
+	c := &counts{}
+	reply := &wrapperspb.StringValue{}
+	assert.NoError(t, interceptor(context.Background(), method, nil, reply, nil, slow(c)))
+	assert.Equal(t, "ok", reply.GetValue())
+	assert.Greater(t, c.calls.Load(), int32(1))
+
+	// other replies can't be, so attempts never run concurrently
+	c = &counts{}
+	other := []string{}
+	assert.NoError(t, interceptor(context.Background(), method, nil, &other, nil, slow(c)))
+	assert.Equal(t, []string{"ok"}, other)
+	assert.Equal(t, int32(1), c.calls.Load())
+	assert.Equal(t, int32(1), c.concurrent.Load())
+}
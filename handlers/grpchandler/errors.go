@@ -0,0 +1,89 @@
+package grpchandler
+
+import (
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/logging"
+
+	otel_codes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// statusError is a gRPC status error which also wraps the typed guard error,
+// so in-process callers can use errors.Is / errors.As on it.
+type statusError struct {
+	s   *status.Status
+	err error
+}
+
+func newStatusError(c codes.Code, msg string, err error, details ...protoadapt.MessageV1) error {
+	s := status.New(c, msg)
+	if len(details) > 0 {
+		if sd, detailsErr := s.WithDetails(details...); detailsErr != nil {
+			logging.Error(detailsErr)
+		} else {
+			s = sd
+		}
+	}
+	return &statusError{s: s, err: err}
+}
+
+func (e *statusError) Error() string {
+	return e.s.Err().Error()
+}
+
+func (e *statusError) GRPCStatus() *status.Status {
+	return e.s
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// ErrorInfo domain for blocked responses
+const errorDomain = "stanzasys.co"
+
+// blocked returns the gRPC status error for a blocked request, with the status
+// code chosen by block reason (see blockedCode) and rich error details.
+func blocked(h *handlers.Handler, span trace.Span, guard *handlers.Guard) error {
+	code := blockedCode(h, guard.BlockReason())
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(code)))
+	span.SetStatus(otel_codes.Error, guard.BlockMessage())
+	return newStatusError(code, guard.BlockMessage(), guard.BlockError(), blockedDetails(code, guard)...)
+}
+
+// blockedCode returns the configured status code for a block reason, or
+// by default: Unauthenticated for token failures, ResourceExhausted otherwise
+func blockedCode(h *handlers.Handler, reason string) codes.Code {
+	if c, ok := h.GrpcCode(reason); ok {
+		return c
+	}
+	if reason == handlers.ReasonTokenNotValid {
+		return codes.Unauthenticated
+	}
+	return codes.ResourceExhausted
+}
+
+// blockedDetails returns the rich error details we attach to blocked responses
+func blockedDetails(code codes.Code, guard *handlers.Guard) []protoadapt.MessageV1 {
+	metadata := map[string]string{"guard": guard.GuardName()}
+	if feature := guard.FeatureName(); feature != "" {
+		metadata["feature"] = feature
+	}
+	details := []protoadapt.MessageV1{
+		&errdetails.ErrorInfo{
+			Reason:   guard.BlockReason(),
+			Domain:   errorDomain,
+			Metadata: metadata,
+		},
+	}
+	if d := guard.RetryAfter(); d > 0 && code == codes.ResourceExhausted {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(d)})
+	}
+	return details
+}
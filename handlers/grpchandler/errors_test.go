@@ -0,0 +1,152 @@
+package grpchandler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/stretchr/testify/assert"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBlockedStatus(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		gc     *hubv1.GuardConfig
+		opts   handlers.Options
+		token  string
+		quota  bool // hub has no quota left
+		hold   bool // another request holds the only concurrency slot
+		code   codes.Code
+		reason string
+		err    error
+		retry  bool // RetryInfo detail
+	}{
+		{
+			name:   "token",
+			gc:     &hubv1.GuardConfig{ValidateIngressTokens: true},
+			token:  hubtest.INVALID_TOKEN,
+			code:   codes.Unauthenticated,
+			reason: handlers.ReasonTokenNotValid,
+			err:    handlers.ErrInvalidToken,
+		},
+		{
+			name:   "token_override",
+			gc:     &hubv1.GuardConfig{ValidateIngressTokens: true},
+			opts:   handlers.Options{GrpcCodes: map[string]codes.Code{handlers.ReasonTokenNotValid: codes.PermissionDenied}},
+			token:  hubtest.INVALID_TOKEN,
+			code:   codes.PermissionDenied,
+			reason: handlers.ReasonTokenNotValid,
+			err:    handlers.ErrInvalidToken,
+		},
+		{
+			name:   "quota",
+			gc:     &hubv1.GuardConfig{CheckQuota: true},
+			quota:  true,
+			code:   codes.ResourceExhausted,
+			reason: handlers.ReasonQuotaBlocked,
+			err:    handlers.ErrQuotaExhausted,
+			retry:  true,
+		},
+		{
+			name:   "quota_override",
+			gc:     &hubv1.GuardConfig{CheckQuota: true},
+			opts:   handlers.Options{GrpcCodes: map[string]codes.Code{handlers.ReasonQuotaBlocked: codes.Unavailable}},
+			quota:  true,
+			code:   codes.Unavailable,
+			reason: handlers.ReasonQuotaBlocked,
+			err:    handlers.ErrQuotaExhausted,
+		},
+		{
+			name:   "local",
+			gc:     &hubv1.GuardConfig{},
+			opts:   handlers.Options{ConcurrencyLimit: 1},
+			hold:   true,
+			code:   codes.ResourceExhausted,
+			reason: handlers.ReasonLocalBlocked,
+			err:    handlers.ErrConcurrencyLimited,
+			retry:  true,
+		},
+		{
+			name: "local_override",
+			gc:   &hubv1.GuardConfig{},
+			opts: handlers.Options{
+				ConcurrencyLimit: 1,
+				GrpcCodes:        map[string]codes.Code{handlers.ReasonLocalBlocked: codes.Unavailable},
+			},
+			hold:   true,
+			code:   codes.Unavailable,
+			reason: handlers.ReasonLocalBlocked,
+			err:    handlers.ErrConcurrencyLimited,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := "blocked-status-" + tt.name
+			hub.SetGuardConfig(guard, tt.gc)
+			hub.SetBlocked(guard, tt.quota)
+			h, err := NewInboundHandlerWithOptions(guard, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.hold {
+				held := h.Guard(context.Background(), nil, nil)
+				assert.True(t, held.Allowed())
+				defer held.End(held.Success)
+			}
+
+			md := metadata.MD{}
+			if tt.token != "" {
+				md.Set("x-stanza-token", tt.token)
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+			next := func(ctx context.Context, req any) (any, error) { return req, nil }
+			_, err = h.NewUnaryServerInterceptor()(ctx, nil, info, next)
+
+			// typed guard errors are still matched in process
+			var blocked *handlers.BlockedError
+			assert.True(t, errors.As(err, &blocked))
+			assert.True(t, errors.Is(err, tt.err))
+
+			s, ok := status.FromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.code, s.Code())
+
+			var errInfo *errdetails.ErrorInfo
+			var retry *errdetails.RetryInfo
+			for _, d := range s.Details() {
+				switch d := d.(type) {
+				case *errdetails.ErrorInfo:
+					errInfo = d
+				case *errdetails.RetryInfo:
+					retry = d
+				}
+			}
+			if assert.NotNil(t, errInfo) {
+				assert.Equal(t, tt.reason, errInfo.GetReason())
+				assert.Equal(t, errorDomain, errInfo.GetDomain())
+				assert.Equal(t, map[string]string{"guard": guard}, errInfo.GetMetadata())
+			}
+			if tt.retry {
+				if assert.NotNil(t, retry) {
+					assert.Greater(t, retry.GetRetryDelay().AsDuration().Nanoseconds(), int64(0))
+				}
+			} else {
+				assert.Nil(t, retry)
+			}
+		})
+	}
+}
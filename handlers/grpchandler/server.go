@@ -10,7 +10,6 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
@@ -21,7 +20,17 @@ type InboundHandler struct {
 
 // NewInboundHandler returns a new InboundHandler
 func NewInboundHandler(gn string, fn *string, pb *int32, dw *float32, kv *map[string]string) (*InboundHandler, error) {
-	h, err := handlers.NewInboundHandler(gn, fn, pb, dw, kv)
+	return NewInboundHandlerWithOptions(gn, handlers.Options{
+		Feature:       fn,
+		PriorityBoost: pb,
+		DefaultWeight: dw,
+		Tags:          kv,
+	})
+}
+
+// NewInboundHandlerWithOptions returns a new InboundHandler configured with handlers.Options
+func NewInboundHandlerWithOptions(gn string, o handlers.Options) (*InboundHandler, error) {
+	h, err := handlers.NewInboundHandlerWithOptions(gn, o)
 	if err != nil {
 		return nil, err
 	}
@@ -78,6 +87,7 @@ func (h *InboundHandler) start(ctx context.Context, spanName string) (context.Co
 		tokens = md.Get("x-stanza-token")
 	}
 	ctx = h.Propagator().Extract(ctx, &otel.MetadataSupplier{Metadata: &md})
+	ctx = h.ExtractTags(ctx, handlers.MetadataTagSource(md))
 
 	opts := []trace.SpanStartOption{
 		trace.WithSpanKind(trace.SpanKindServer),
@@ -95,20 +105,18 @@ func (h *InboundHandler) allowed(span trace.Span, guard *handlers.Guard, err err
 	s, _ := status.FromError(err)
 	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(s.Code())))
 
+	outcome := handlers.Outcome{Status: guard.Success, Err: err, Code: int(s.Code())}
 	if err != nil {
 		span.SetStatus(otel_codes.Error, s.Message())
 		span.RecordError(err)
-		guard.End(guard.Failure)
+		outcome.Status = guard.Failure
 	} else {
 		span.SetStatus(otel_codes.Ok, "OK")
-		guard.End(guard.Success)
 	}
+	guard.EndWith(outcome)
 	return err
 }
 
 func (h *InboundHandler) blocked(span trace.Span, guard *handlers.Guard) error {
-	// TODO: codes.ResourceExhausted is wrong for failed token check
-	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(codes.ResourceExhausted)))
-	span.SetStatus(otel_codes.Error, guard.BlockMessage())
-	return status.Errorf(codes.ResourceExhausted, guard.BlockMessage())
+	return blocked(h.Handler, span, guard)
 }
@@ -0,0 +1,47 @@
+package grpchandler
+
+import (
+	"context"
+	"testing"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func BenchmarkUnaryServerInterceptor(b *testing.B) {
+	bench := func(b *testing.B, guard string, gc *hubv1.GuardConfig, o handlers.Options) {
+		hub, err := hubtest.Start()
+		if err != nil {
+			b.Fatal(err)
+		}
+		hub.SetGuardConfig(guard, gc)
+		h, err := NewInboundHandlerWithOptions(guard, o)
+		if err != nil {
+			b.Fatal(err)
+		}
+		interceptor := h.NewUnaryServerInterceptor()
+		info := &grpc.UnaryServerInfo{FullMethod: "/bench.Service/Method"}
+		next := func(ctx context.Context, req any) (any, error) { return req, nil }
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{})
+		interceptor(ctx, nil, info, next) // fetch the guard config
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			interceptor(ctx, nil, info, next)
+		}
+	}
+	b.Run("disabled", func(b *testing.B) {
+		bench(b, "bench-grpc-disabled", &hubv1.GuardConfig{}, handlers.Options{})
+	})
+	b.Run("fast_path", func(b *testing.B) {
+		bench(b, "bench-grpc-fast-path", &hubv1.GuardConfig{}, handlers.Options{FastPath: true})
+	})
+	b.Run("quota", func(b *testing.B) {
+		bench(b, "bench-grpc-quota", &hubv1.GuardConfig{CheckQuota: true}, handlers.Options{})
+	})
+}
@@ -2,16 +2,21 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
 	"github.com/StanzaSystems/sdk-go/global"
 	"github.com/StanzaSystems/sdk-go/hub"
+	"github.com/StanzaSystems/sdk-go/limiter"
 	"github.com/StanzaSystems/sdk-go/logging"
 
 	"github.com/alibaba/sentinel-golang/api"
 	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
+	"github.com/alibaba/sentinel-golang/core/flow"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -29,57 +34,92 @@ type Guard struct {
 	tlr   *hubv1.GetTokenLeaseRequest
 	meter *global.StanzaMeter
 	span  trace.Span
-	attr  []attribute.KeyValue
+	attr  []attribute.KeyValue // handler (and any extra) attributes, shared, never append to it
+	sets  *attrCache           // nil when there are extra attributes
 	err   error
 
+	customer string
+
 	Success int
 	Failure int
 	Unknown int
 
 	configStatus hubv1.Config
 	config       *hubv1.GuardConfig
+	shadow       bool // evaluate every check, but always allow
+	tokens       []string
+	checks       []checkStatus // custom checks (if any)
 
 	localStatus hubv1.Local
 	localBlock  *base.BlockError
+	localEntry  atomic.Pointer[base.SentinelEntry] // held between Handler.Guard and End
+	localLimit  int                                // concurrency limit which blocked (if any)
+	localShed   string                             // priority band which was shed (if any)
+
+	// in-flight slots held between Handler.Guard and End
+	limiters []limiter.Limiter
+	acquired time.Time
+	watched  bool // registered with the leak sweeper (see watchLeak)
+	released atomic.Bool
+
+	ended     atomic.Bool
+	autoEnd   func() bool // stops ending the guard when its ctx is done
+	fast      bool        // allowed by the fast path, nothing is recorded
+	isFailure func(error) bool
 
 	tokenStatus hubv1.Token
 
-	quotaStatus hubv1.Quota
-	quotaToken  string
+	quotaStatus  hubv1.Quota
+	quotaToken   string
+	quotaUnknown bool // shadow mode couldn't tell what quota would decide
+
+	// set when hub rejected our API key during the matching check
+	configAuthErr bool
+	configNotInit bool // used before Init
+	tokenAuthErr  bool
+	quotaAuthErr  bool
 }
 
 func (g *Guard) Allowed() bool {
-	// Report Only mode always allows
-	if g.config != nil && g.config.ReportOnly {
-		return true
-	}
-
-	// Default to "allowed", unless one of our checks *explicitly* blocks
-	if g.localStatus != hubv1.Local_LOCAL_BLOCKED &&
-		g.quotaStatus != hubv1.Quota_QUOTA_BLOCKED &&
-		g.tokenStatus != hubv1.Token_TOKEN_NOT_VALID {
-		return true
-	}
-	return false
+	return !g.Blocked()
 }
 
+// Blocked reports whether the request should be blocked. Guards in Report Only
+// or shadow mode never block, see WouldBlock.
 func (g *Guard) Blocked() bool {
-	// Report Only mode always allows
-	if g.config != nil && g.config.ReportOnly {
+	// Report Only and shadow modes always allow
+	if g.shadow || (g.config != nil && g.config.ReportOnly) {
 		return false
 	}
+	return g.WouldBlock()
+}
 
+// WouldBlock reports whether any check blocked the request, even if it was
+// allowed anyway (in Report Only or shadow mode)
+func (g *Guard) WouldBlock() bool {
 	// Default to "allowed", unless one of our checks *explicitly* blocks
 	if g.localStatus == hubv1.Local_LOCAL_BLOCKED ||
 		g.quotaStatus == hubv1.Quota_QUOTA_BLOCKED ||
-		g.tokenStatus == hubv1.Token_TOKEN_NOT_VALID {
+		g.tokenStatus == hubv1.Token_TOKEN_NOT_VALID ||
+		g.blockedCheck() != nil {
 		return true
 	}
 	return false
 }
 
+// Shadow reports whether the guard was evaluated in shadow mode
+func (g *Guard) Shadow() bool {
+	return g.shadow
+}
+
 func (g *Guard) BlockMessage() string {
 	if g.localStatus == hubv1.Local_LOCAL_BLOCKED {
+		if g.localShed != "" {
+			return fmt.Sprintf("Stanza shed %s priority traffic. Please try again later.", g.localShed)
+		}
+		if g.localBlock == nil {
+			return "Stanza concurrency limit reached. Please try again later."
+		}
 		return g.localBlock.BlockMsg()
 	}
 	if g.tokenStatus == hubv1.Token_TOKEN_NOT_VALID {
@@ -88,6 +128,9 @@ func (g *Guard) BlockMessage() string {
 	if g.quotaStatus == hubv1.Quota_QUOTA_BLOCKED {
 		return "Stanza quota exhausted. Please try again later."
 	}
+	if cs := g.blockedCheck(); cs != nil {
+		return cs.message
+	}
 	return ""
 }
 
@@ -101,13 +144,84 @@ func (g *Guard) BlockReason() string {
 	if g.quotaStatus == hubv1.Quota_QUOTA_BLOCKED {
 		return g.quotaStatus.String()
 	}
+	if cs := g.blockedCheck(); cs != nil {
+		return cs.reason
+	}
 	return ""
 }
 
+// BlockError returns a *BlockedError if the guard blocked, nil otherwise
+func (g *Guard) BlockError() error {
+	if !g.Blocked() {
+		return nil
+	}
+	var err error
+	switch {
+	case g.localStatus == hubv1.Local_LOCAL_BLOCKED:
+		err = &LocalBlockedError{Block: g.localBlock, Limit: g.localLimit, Band: g.localShed}
+	case g.tokenStatus == hubv1.Token_TOKEN_NOT_VALID:
+		err = ErrInvalidToken
+	case g.quotaStatus == hubv1.Quota_QUOTA_BLOCKED:
+		err = ErrQuotaExhausted
+	case g.blockedCheck() != nil:
+		err = &CheckBlockedError{Stage: g.blockedCheck().name}
+	}
+	return &BlockedError{
+		Guard:   g.GuardName(),
+		Feature: g.FeatureName(),
+		Reason:  g.BlockReason(),
+		Message: g.BlockMessage(),
+		Err:     err,
+	}
+}
+
+// RetryAfter suggests how long a blocked request should wait before it is
+// retried, zero if it isn't blocked (or if retrying won't help, like when
+// ingress token validation failed).
+func (g *Guard) RetryAfter() time.Duration {
+	if !g.Blocked() {
+		return 0
+	}
+	if g.localStatus == hubv1.Local_LOCAL_BLOCKED {
+		if g.localBlock != nil {
+			switch rule := g.localBlock.TriggeredRule().(type) {
+			case *circuitbreaker.Rule:
+				if rule.RetryTimeoutMs > 0 {
+					return time.Duration(rule.RetryTimeoutMs) * time.Millisecond
+				}
+			case *flow.Rule:
+				if rule.StatIntervalInMs > 0 {
+					return time.Duration(rule.StatIntervalInMs) * time.Millisecond
+				}
+			}
+		}
+		return hub.DEFAULT_RETRY_AFTER
+	}
+	if g.tokenStatus == hubv1.Token_TOKEN_NOT_VALID {
+		return 0
+	}
+	if g.quotaStatus == hubv1.Quota_QUOTA_BLOCKED {
+		return hub.RetryAfter(g.GuardName())
+	}
+	if cs := g.blockedCheck(); cs != nil && cs.retry > 0 {
+		return cs.retry
+	}
+	return hub.DEFAULT_RETRY_AFTER
+}
+
+func (g *Guard) GuardName() string {
+	return g.tlr.GetSelector().GetGuardName()
+}
+
+func (g *Guard) FeatureName() string {
+	return g.tlr.GetSelector().GetFeatureName()
+}
+
 func (g *Guard) Token() string {
 	return g.quotaToken
 }
 
+// Error returns a *FailOpenError if any guard stage failed open, nil otherwise
 func (g *Guard) Error() error {
 	return g.err
 }
@@ -116,28 +230,69 @@ func (g *Guard) Context() context.Context {
 	return g.ctx
 }
 
+// End records the outcome of the guarded work, only the first call has any
+// effect (see EndWith to record more than its status)
 func (g *Guard) End(status int) {
+	if g.autoEnd != nil {
+		g.autoEnd()
+	}
+	g.end(status, nil)
+}
+
+func (g *Guard) end(status int, o *Outcome) {
+	if !g.ended.CompareAndSwap(false, true) || g.fast {
+		return
+	}
+	untrack(g)
+	g.unwatchLeak()
+	if status == g.Failure {
+		if o != nil && o.Err != nil {
+			g.exitLocal(o.Err)
+		} else {
+			g.exitLocal(errGuardFailure)
+		}
+	} else {
+		g.exitLocal(nil)
+	}
+	if !g.acquired.IsZero() {
+		// measure the guarded work itself (if we got that far), not our checks
+		if g.start.IsZero() {
+			g.release(time.Since(g.acquired), status == g.Failure)
+		} else {
+			g.release(time.Since(g.start), status == g.Failure)
+		}
+	}
+	as := g.attrs(false)
+	if o != nil {
+		as = g.outcome(o)
+	}
 	if !g.start.IsZero() {
-		g.meter.AllowedDuration.Record(g.ctx,
-			float64(time.Since(g.start).Microseconds())/1000,
-			[]metric.RecordOption{metric.WithAttributes(g.attr...)}...)
+		g.meter.AllowedDuration.Record(g.ctx, float64(time.Since(g.start).Microseconds())/1000, as.record...)
 	}
 	if status == g.Success {
-		g.meter.AllowedSuccessCount.Add(g.ctx, 1, []metric.AddOption{metric.WithAttributes(g.attr...)}...)
+		g.meter.AllowedSuccessCount.Add(g.ctx, 1, as.add...)
 	}
 	if status == g.Failure {
-		g.meter.AllowedFailureCount.Add(g.ctx, 1, []metric.AddOption{metric.WithAttributes(g.attr...)}...)
+		g.meter.AllowedFailureCount.Add(g.ctx, 1, as.add...)
 	}
 	if status == g.Unknown {
-		g.meter.AllowedUnknownCount.Add(g.ctx, 1, []metric.AddOption{metric.WithAttributes(g.attr...)}...)
+		g.meter.AllowedUnknownCount.Add(g.ctx, 1, as.add...)
 	}
 }
 
 func (g *Guard) getGuardConfig(ctx context.Context, name string) (hubv1.Config, error) {
-	g.config, g.configStatus, g.err = global.GetGuardConfig(ctx, name)
-	if g.err != nil {
-		logging.Error(g.err)
-		g.failopen(ctx, g.err)
+	var err error
+	g.config, g.configStatus, err = global.GetGuardConfig(ctx, name)
+	if err != nil {
+		g.configAuthErr = global.IsAuthError(err)
+		g.configNotInit = errors.Is(err, global.ErrNotInitialized)
+		g.err = failOpenError(StageConfig, err)
+		if g.configNotInit {
+			logging.Debug("guard used before stanza.Init, failing open", "guard", name)
+		} else {
+			logging.Error(err)
+		}
+		g.failopen(ctx, err)
 	}
 	return g.configStatus, g.err
 }
@@ -145,6 +300,14 @@ func (g *Guard) getGuardConfig(ctx context.Context, name string) (hubv1.Config,
 func (g *Guard) checkLocal(ctx context.Context, name string, enabled bool) error {
 	if !enabled {
 		g.localStatus = hubv1.Local_LOCAL_EVAL_DISABLED
+	} else if g.shadow {
+		// estimated without an entry, see shadowLocal
+		if g.localBlock = shadowLocal(name); g.localBlock != nil {
+			g.blocked(ctx)
+			g.localStatus = hubv1.Local_LOCAL_BLOCKED
+		} else {
+			g.localStatus = hubv1.Local_LOCAL_ALLOWED
+		}
 	} else {
 		e, b := api.Entry(name, api.WithTrafficType(base.Inbound), api.WithResourceType(base.ResTypeWeb))
 		if b != nil {
@@ -159,20 +322,38 @@ func (g *Guard) checkLocal(ctx context.Context, name string, enabled bool) error
 			g.blocked(ctx)
 			g.localStatus = hubv1.Local_LOCAL_BLOCKED
 		} else {
-			e.Exit() // cleanly exit the Sentinel Entry
+			// held until End, so circuit breakers (and isolation rules) see
+			// the outcome of the guarded work rather than of our checks
+			g.localEntry.Store(e)
 			g.localStatus = hubv1.Local_LOCAL_ALLOWED
 		}
 	}
 	return nil
 }
 
+// exitLocal exits the Sentinel entry held since checkLocal (only the first
+// call has any effect), recording err (if any) as the entry's error
+func (g *Guard) exitLocal(err error) {
+	e := g.localEntry.Swap(nil)
+	if e == nil {
+		return
+	}
+	if err != nil {
+		api.TraceError(e, err)
+	}
+	e.Exit()
+}
+
 func (g *Guard) checkToken(ctx context.Context, name string, tokens []string, enabled bool) error {
 	if !enabled {
 		g.tokenStatus = hubv1.Token_TOKEN_EVAL_DISABLED
 	} else {
-		g.tokenStatus, g.err = hub.ValidateTokens(ctx, name, tokens)
-		if g.err != nil {
-			g.failopen(ctx, g.err)
+		var err error
+		g.tokenStatus, err = hub.ValidateTokens(ctx, name, tokens)
+		if err != nil {
+			g.tokenAuthErr = global.IsAuthError(err)
+			g.err = failOpenError(StageToken, err)
+			g.failopen(ctx, err)
 		}
 		if g.tokenStatus == hubv1.Token_TOKEN_NOT_VALID {
 			g.blocked(ctx)
@@ -186,9 +367,17 @@ func (g *Guard) checkQuota(ctx context.Context, tlr *hubv1.GetTokenLeaseRequest,
 	if !enabled {
 		g.quotaStatus = hubv1.Quota_QUOTA_EVAL_DISABLED
 	} else {
-		g.quotaStatus, g.quotaToken, g.err = hub.CheckQuota(ctx, tlr)
-		if g.err != nil {
-			g.failopen(ctx, g.err)
+		var err error
+		if g.shadow {
+			g.quotaStatus, err = hub.SimulateQuota(tlr) // never consume real quota
+			g.quotaUnknown = g.quotaStatus == hubv1.Quota_QUOTA_UNSPECIFIED
+		} else {
+			g.quotaStatus, g.quotaToken, err = hub.CheckQuota(ctx, tlr)
+		}
+		if err != nil {
+			g.quotaAuthErr = global.IsAuthError(err)
+			g.err = failOpenError(StageQuota, err)
+			g.failopen(ctx, err)
 		}
 		if g.quotaStatus == hubv1.Quota_QUOTA_BLOCKED {
 			g.blocked(ctx)
@@ -198,50 +387,74 @@ func (g *Guard) checkQuota(ctx context.Context, tlr *hubv1.GetTokenLeaseRequest,
 }
 
 func (g *Guard) allowed(ctx context.Context) {
-	g.meter.AllowedCount.Add(ctx, 1, g.metricAttr()...)
-	g.span.AddEvent("Stanza allowed", g.traceAttr(nil))
-	logging.Debug("Stanza allowed", g.logAttr(nil)...)
+	g.record(ctx, g.meter.AllowedCount, "Stanza allowed", nil)
 	g.start = time.Now()
 }
 
 func (g *Guard) blocked(ctx context.Context) {
-	g.meter.BlockedCount.Add(ctx, 1, g.metricAttr()...)
-	g.span.AddEvent("Stanza blocked", g.traceAttr(nil))
-	logging.Debug("Stanza blocked", g.logAttr(nil)...)
+	g.record(ctx, g.meter.BlockedCount, "Stanza blocked", nil)
 }
 
 func (g *Guard) failopen(ctx context.Context, err error) {
-	g.meter.FailOpenCount.Add(ctx, 1, g.metricAttr()...)
-	g.span.AddEvent("Stanza failed open", g.traceAttr(err))
-	logging.Debug("Stanza failed open", g.logAttr(err)...)
+	g.record(ctx, g.meter.FailOpenCount, "Stanza failed open", err)
 }
 
-func (g *Guard) reasons() []attribute.KeyValue {
-	kvs := g.attr
-	kvs = append(kvs, configReasonKey.String(g.configStatus.String()))
-	kvs = append(kvs, localReasonKey.String(g.localStatus.String()))
-	kvs = append(kvs, tokenReasonKey.String(g.tokenStatus.String()))
-	kvs = append(kvs, quotaReasonKey.String(g.quotaStatus.String()))
-	if g.config != nil {
-		if g.config.ReportOnly {
-			kvs = append(kvs, modeKey.String(hubv1.Mode_MODE_REPORT_ONLY.String()))
-		} else {
-			kvs = append(kvs, modeKey.String(hubv1.Mode_MODE_NORMAL.String()))
-		}
+// record counts the guard decision and adds it to the span and the debug log,
+// span and log attributes are only built if they are going to be used
+func (g *Guard) record(ctx context.Context, counter metric.Int64Counter, msg string, err error) {
+	as := g.attrs(true)
+	counter.Add(ctx, 1, as.add...)
+	if g.span != nil && g.span.IsRecording() {
+		g.span.AddEvent(msg, g.traceAttr(as, err))
+	}
+	if logging.DebugEnabled() {
+		logging.Debug(msg, g.logAttr(err)...)
+	}
+}
+
+func (g *Guard) configReason() string {
+	if g.configAuthErr {
+		return configAuthError
+	}
+	if g.configNotInit {
+		return configNotInitialized
+	}
+	return g.configStatus.String()
+}
+
+func (g *Guard) localReason() string {
+	if g.localShed != "" {
+		return localPriorityShed
 	}
-	return kvs
+	if g.localLimit > 0 {
+		return localConcurrencyLimited
+	}
+	return g.localStatus.String()
+}
+
+func (g *Guard) tokenReason() string {
+	if g.tokenAuthErr {
+		return tokenAuthError
+	}
+	return g.tokenStatus.String()
 }
 
-func (g *Guard) metricAttr() []metric.AddOption {
-	return []metric.AddOption{metric.WithAttributes(g.reasons()...)}
+func (g *Guard) quotaReason() string {
+	if g.quotaAuthErr {
+		return quotaAuthError
+	}
+	if g.quotaUnknown {
+		return quotaShadowUnknown
+	}
+	return g.quotaStatus.String()
 }
 
-func (g *Guard) traceAttr(err error) trace.SpanStartEventOption {
+func (g *Guard) traceAttr(as *attrSet, err error) trace.SpanStartEventOption {
 	var resp []attribute.KeyValue
 	if err != nil {
 		resp = append(resp, errorKey.String(err.Error()))
 	}
-	resp = append(resp, g.reasons()...)
+	resp = append(resp, as.set.ToSlice()...)
 	return trace.WithAttributes(resp...)
 }
 
@@ -271,19 +484,24 @@ func (g *Guard) logAttr(err error) []interface{} {
 
 	// Add reason attributes
 	resp = append(resp,
-		configReason, g.configStatus.String(),
-		localReason, g.localStatus.String(),
-		tokenReason, g.tokenStatus.String(),
-		quotaReason, g.quotaStatus.String(),
+		configReason, g.configReason(),
+		localReason, g.localReason(),
+		tokenReason, g.tokenReason(),
+		quotaReason, g.quotaReason(),
 	)
+	if g.localShed != "" {
+		resp = append(resp, priorityBand, g.localShed)
+	}
+	for _, cs := range g.checks {
+		resp = append(resp, cs.name+"_reason", cs.reason)
+	}
 
-	// Add mode attribute
-	if g.config != nil {
-		if g.config.ReportOnly {
-			resp = append(resp, "mode", hubv1.Mode_MODE_REPORT_ONLY.String())
-		} else {
-			resp = append(resp, "mode", hubv1.Mode_MODE_NORMAL.String())
-		}
+	// Add mode attributes
+	if g.shadow {
+		resp = append(resp, "shadow", true)
+	}
+	if mode := g.mode(); mode != "" {
+		resp = append(resp, "mode", mode)
 	}
 
 	return resp
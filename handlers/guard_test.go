@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/global"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/StanzaSystems/sdk-go/limiter"
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalEntryHeldUntilEnd(t *testing.T) {
+	const guard = "local-entry-guard"
+	_, err := circuitbreaker.LoadRules([]*circuitbreaker.Rule{{
+		Resource:         guard,
+		Strategy:         circuitbreaker.ErrorCount,
+		RetryTimeoutMs:   60000,
+		MinRequestAmount: 1,
+		StatIntervalMs:   60000,
+		Threshold:        2,
+	}})
+	assert.NoError(t, err)
+	t.Cleanup(func() { circuitbreaker.ClearRulesOfResource(guard) })
+
+	h := &Handler{guardName: guard}
+	local := func() *Guard {
+		g := h.NewGuard(context.Background(), nil, nil, nil)
+		g.meter = global.NewStanzaMeter() // the global state isn't set up
+		g.checkLocal(context.Background(), guard, true)
+		return g
+	}
+
+	// successes don't count towards the breaker, failures do (only once the
+	// guarded work ended)
+	for i := 0; i < 3; i++ {
+		g := local()
+		assert.NotNil(t, g.localEntry.Load())
+		g.EndWith(Outcome{})
+		assert.Nil(t, g.localEntry.Load())
+	}
+	var failed []*Guard
+	for i := 0; i < 3; i++ {
+		g := local()
+		assert.Equal(t, hubv1.Local_LOCAL_ALLOWED, g.localStatus)
+		failed = append(failed, g)
+	}
+	for _, g := range failed {
+		g.EndWith(Outcome{Err: errors.New("failed")})
+	}
+
+	g := local()
+	assert.Equal(t, hubv1.Local_LOCAL_BLOCKED, g.localStatus)
+	assert.Nil(t, g.localEntry.Load())
+}
+
+// releases records every release of an adaptive limit's slots (whether the
+// guard failed or not)
+type releases struct {
+	mu      sync.Mutex
+	dropped []bool
+}
+
+func (r *releases) InitialLimit() int { return 1 }
+
+func (r *releases) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropped = append(r.dropped, dropped)
+	return limit
+}
+
+func (r *releases) get() []bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]bool{}, r.dropped...)
+}
+
+func TestAutoEnd(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetGuardConfig("auto-end-guard", &hubv1.GuardConfig{})
+	r := &releases{}
+	h, _ := NewHandlerWithOptions("auto-end-guard", Options{
+		AutoEnd:       true,
+		AdaptiveLimit: func() limiter.Algorithm { return r },
+	})
+
+	// ended with Unknown once its ctx is done, a later End has no effect
+	ctx, cancel := context.WithCancel(context.Background())
+	g := h.Guard(ctx, nil, nil)
+	assert.True(t, g.Allowed())
+	cancel()
+	assert.Eventually(t, g.ended.Load, time.Second, time.Millisecond)
+	g.End(g.Failure)
+	assert.Equal(t, []bool{false}, r.get())
+	assert.Equal(t, 0, h.limiter.InFlight())
+
+	// End racing the auto end releases the slot exactly once
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		g := h.Guard(ctx, nil, nil)
+		if !assert.True(t, g.Allowed()) {
+			cancel()
+			break
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); cancel() }()
+		go func() { defer wg.Done(); g.End(g.Success) }()
+		wg.Wait()
+		assert.Eventually(t, func() bool { return len(r.get()) == i+2 }, time.Second, time.Millisecond)
+		assert.Equal(t, 0, h.limiter.InFlight())
+	}
+	time.Sleep(10 * time.Millisecond) // any late second release
+	assert.Len(t, r.get(), 101)
+	assert.Equal(t, 0, h.limiter.InFlight())
+}
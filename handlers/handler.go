@@ -2,99 +2,318 @@ package handlers
 
 import (
 	"context"
+	"net/http"
+	"slices"
 	"time"
 
 	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
 	"github.com/StanzaSystems/sdk-go/global"
 	"github.com/StanzaSystems/sdk-go/hub"
+	"github.com/StanzaSystems/sdk-go/keys"
+	"github.com/StanzaSystems/sdk-go/limiter"
 	"github.com/StanzaSystems/sdk-go/otel"
 
+	"github.com/alibaba/sentinel-golang/core/system_metric"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
 )
 
+// spans started by Handler.Guard itself (when the caller didn't pass one)
+var guardSpanOptions = []trace.SpanStartOption{
+	trace.WithSpanKind(trace.SpanKindUnspecified),
+}
+
 type Handler struct {
 	guardName     string
 	featureName   *string // overrides request baggage (if any)
 	priorityBoost *int32  // adds to request baggage (if any)
 	defaultWeight *float32
 	tags          *map[string]string
-	attr          []attribute.KeyValue
+	timeout       time.Duration
+	isFailure     func(error) bool
+	grpcCodes     map[string]codes.Code
+	blocked       func(http.ResponseWriter, *http.Request, *Guard)
+	autoEnd       bool
+	shadow        bool
+	checks        []Check
+	customChecks  []checkStatus // initial status of the custom checks (if any)
+	tagExtractors []*tagExtractor
+	tagsKey       keys.ContextKey // context key of the tags extracted for this guard
+
+	limiter         limiter.Limiter            // guard wide concurrency limit (if any)
+	featureLimiters map[string]limiter.Limiter // per feature concurrency limits
+	leakTimeout     time.Duration
+
+	featurePriorities map[string]int32
+	shedder           *limiter.Shedder // sheds low priority traffic first (if any)
+
+	fast        fastPathCache
+	guardConfig func(string) (*hubv1.GuardConfig, string) // cached guard config lookup
+
+	retry       *RetryPolicy // outbound calls only (if any)
+	retryBudget *retryBudget
+
+	attr     []attribute.KeyValue
+	attrSets *attrCache // per (feature, reasons, etc) combination, see sharedAttrCache
+}
+
+// Options configures a Handler
+type Options struct {
+	Feature       *string // overrides request baggage (if any)
+	PriorityBoost *int32  // adds to request baggage (if any)
+	DefaultWeight *float32
+	Tags          *map[string]string
+	Timeout       time.Duration    // max time to wait on Stanza Hub before failing open (0 for SDK default)
+	IsFailure     func(error) bool // classifies errors as failures (default: any non-nil error)
+
+	// gRPC status codes to return for blocked requests, keyed by block reason
+	// (see Guard.BlockReason); unset reasons use the grpchandler defaults
+	GrpcCodes map[string]codes.Code
+
+	// writes the response for requests blocked by HTTP middleware (default:
+	// httphandler.BlockedText)
+	BlockedHandler func(http.ResponseWriter, *http.Request, *Guard)
+
+	// end allowed guards with Unknown status when their context is done (if
+	// they haven't been ended already)
+	AutoEnd bool
+
+	// run every check and report would-block metrics, spans, and logs (with a
+	// shadow=true attribute), but always allow and never consume real quota
+	// (or concurrency slots and Sentinel entries)
+	Shadow bool
+
+	// the guard pipeline, in order (default: DefaultChecks)
+	Checks []Check
+
+	// fill in quota tags from requests (used by the HTTP and gRPC middleware)
+	TagExtractors []TagExtractor
+
+	ConcurrencyLimit         int                      // max in-flight requests for this guard (0 for unlimited)
+	AdaptiveLimit            func() limiter.Algorithm // adjusts the guard wide limit (overrides ConcurrencyLimit)
+	FeatureConcurrencyLimits map[string]int           // max in-flight requests per feature
+	LeakTimeout              time.Duration            // free slots of guards not ended within this long (0 for DEFAULT_LEAK_TIMEOUT)
+
+	// base local priority per feature (default: limiter.PriorityNormal), the
+	// request's PriorityBoost is added to it
+	FeaturePriorities map[string]int32
+
+	// shed low priority traffic first as in-flight requests approach the
+	// concurrency limits (each priority band may only use its share of them,
+	// see limiter.DEFAULT_BAND_SHARES), without it the limits apply to all
+	// traffic alike
+	PriorityShedding bool
+
+	// shed low priority traffic first as process CPU usage (0-1) approaches
+	// this threshold (0 disables)
+	CPUThreshold float64
+
+	// skip the guard pipeline entirely (no spans, metrics, or logs) while the
+	// guard's config has nothing to check: no quota, no ingress tokens, and no
+	// Sentinel rules. Ignored with Shadow, custom Checks, or local limits.
+	FastPath bool
+
+	// retry (and hedge) calls of outbound handlers
+	Retry *RetryPolicy
 }
 
 func NewHandler(gn string, fn *string, pb *int32, dw *float32, kv *map[string]string) (*Handler, error) {
-	return &Handler{
+	return NewHandlerWithOptions(gn, Options{
+		Feature:       fn,
+		PriorityBoost: pb,
+		DefaultWeight: dw,
+		Tags:          kv,
+	})
+}
+
+func NewHandlerWithOptions(gn string, o Options) (*Handler, error) {
+	// prefetch guard config in the background, before the first request
+	global.RegisterGuard(gn)
+
+	var l limiter.Limiter
+	if o.AdaptiveLimit != nil {
+		l = sharedLimiter(gn, "", func() limiter.Limiter {
+			return limiter.NewAdaptive(o.AdaptiveLimit())
+		})
+	} else if o.ConcurrencyLimit > 0 {
+		l = sharedLimiter(gn, "", func() limiter.Limiter {
+			return limiter.NewFixed(o.ConcurrencyLimit)
+		})
+	}
+	featureLimiters := map[string]limiter.Limiter{}
+	for feature, limit := range o.FeatureConcurrencyLimits {
+		if limit > 0 {
+			featureLimiters[feature] = sharedLimiter(gn, feature, func() limiter.Limiter {
+				return limiter.NewFixed(limit)
+			})
+		}
+	}
+
+	// shed by priority if asked to, and there is a capacity to measure load against
+	limitShedding := o.PriorityShedding && (l != nil || len(featureLimiters) > 0)
+	var shedder *limiter.Shedder
+	if limitShedding || o.CPUThreshold > 0 {
+		shedder = &limiter.Shedder{Limits: limitShedding, CPUThreshold: o.CPUThreshold, CPUUsage: cpuUsage}
+		if o.CPUThreshold > 0 {
+			system_metric.InitCpuCollector(1000)
+		}
+	}
+
+	checks := DefaultChecks()
+	if o.Checks != nil {
+		var err error
+		if checks, err = ValidateChecks(o.Checks); err != nil {
+			return nil, err
+		}
+	}
+
+	h := &Handler{
 		guardName:     gn,
-		featureName:   fn,
-		priorityBoost: pb,
-		defaultWeight: dw,
-		tags:          kv,
+		featureName:   o.Feature,
+		priorityBoost: o.PriorityBoost,
+		defaultWeight: o.DefaultWeight,
+		tags:          o.Tags,
+		timeout:       o.Timeout,
+		isFailure:     o.IsFailure,
+		grpcCodes:     o.GrpcCodes,
+		blocked:       o.BlockedHandler,
+		autoEnd:       o.AutoEnd,
+		shadow:        o.Shadow,
+		checks:        checks,
+		customChecks:  customChecks(checks),
+		tagExtractors: newTagExtractors(o.TagExtractors),
+		tagsKey:       keys.ContextKey("stanza-extracted-tags-" + gn),
+
+		limiter:         l,
+		featureLimiters: featureLimiters,
+		leakTimeout:     o.LeakTimeout,
+
+		featurePriorities: o.FeaturePriorities,
+		shedder:           shedder,
+
+		guardConfig: global.CachedGuardConfig,
+
 		attr: []attribute.KeyValue{
 			clientIdKey.String(global.GetClientID()),
 			environmentKey.String(global.GetServiceEnvironment()),
 			serviceKey.String(global.GetServiceName()),
 		},
-	}, nil
+	}
+	h.attrSets = sharedAttrCache(gn, h.attr, h.customChecks)
+	if o.Retry != nil {
+		h.retry = o.Retry
+		h.retryBudget = sharedBudget(gn, o.Retry.Budget)
+	}
+	h.fast.enabled = o.FastPath && !o.Shadow && o.Checks == nil && l == nil && len(featureLimiters) == 0 && shedder == nil
+	return h, nil
 }
 
 func (h *Handler) Guard(ctx context.Context, span trace.Span, tokens []string) *Guard {
+	if g := h.fastGuard(ctx); g != nil {
+		return g
+	}
 	if span == nil {
 		// Default OTEL Tracer if none specified
-		opts := []trace.SpanStartOption{
-			trace.WithSpanKind(trace.SpanKindUnspecified),
-		}
-		ctx, span = h.Tracer().Start(ctx, h.GuardName(), opts...)
+		ctx, span = h.Tracer().Start(ctx, h.GuardName(), guardSpanOptions...)
 		defer span.End()
 	}
 
-	ctx, tlr := hub.NewTokenLeaseRequest(ctx, h.GuardName(), h.FeatureName(), h.PriorityBoost(), h.DefaultWeight(), h.Tags())
-	attr := []attribute.KeyValue{
-		guardKey.String(tlr.Selector.GetGuardName()),
-		featureKey.String(tlr.Selector.GetFeatureName()),
-		priorityBoostKey.Int64(int64(tlr.GetPriorityBoost())),
-	}
-	g := h.NewGuard(ctx, span, attr, nil)
+	ctx, tlr := hub.NewTokenLeaseRequest(ctx, h.GuardName(), h.FeatureName(), h.PriorityBoost(), h.DefaultWeight(), h.tagsFor(ctx))
+	g := h.newGuard(ctx, span, tlr, nil)
+	g.shadow = h.shadow || isShadow(ctx)
+	g.tokens = tokens
+	g.checks = slices.Clone(h.customChecks)
 
-	// Config State check
-	_, err := g.getGuardConfig(ctx, h.guardName)
-	if err != nil || g.config == nil {
-		return g
-	}
+	defer h.guarded(g)
 
-	// Local (Sentinel) check
-	err = g.checkLocal(ctx, h.guardName, h.SentinelEnabled())
-	if err != nil || g.localStatus == hubv1.Local_LOCAL_BLOCKED {
+	// Priority shedding and concurrency limit checks (in-process, the slot is
+	// held until End, shadow guards never hold one)
+	limiters := h.limitersFor(g.FeatureName())
+	if h.shedder != nil {
+		band := limiter.Band(h.priority(g.FeatureName(), tlr.GetPriorityBoost()))
+		if !h.shedder.Admit(band, limiters) {
+			g.shed(ctx, band)
+			if !g.shadow {
+				return g
+			}
+			limiters = nil // would be shed already
+		}
+	}
+	if g.shadow {
+		g.wouldAcquire(ctx, limiters)
+	} else if !g.acquire(ctx, limiters, h.leakTimeout) {
 		return g
 	}
 
-	// Ingress token check
-	err = g.checkToken(ctx, h.guardName, tokens, g.config.ValidateIngressTokens)
-	if err != nil || g.tokenStatus == hubv1.Token_TOKEN_NOT_VALID {
-		return g
+	// Bound how long we wait on Stanza Hub (the guard keeps the original ctx)
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
 	}
 
-	// Quota check
-	err = g.checkQuota(ctx, tlr, g.config.CheckQuota)
-	if err != nil || g.quotaStatus == hubv1.Quota_QUOTA_BLOCKED {
+	// Config, Local (Sentinel), ingress token, and quota checks, plus any
+	// custom ones (shadow mode keeps going after a would-block)
+	if !g.runChecks(ctx, h.checks) {
 		return g
 	}
 
-	g.allowed(ctx)
+	if g.WouldBlock() {
+		g.start = time.Now() // shadow mode, allowed anyway
+	} else {
+		g.allowed(ctx)
+	}
 	return g
 }
 
+// guarded finishes setting up a guard returned by Guard: blocked guards are
+// never ended so their concurrency slots are released right away, while
+// allowed ones may be ended automatically and tracked until they are ended
+func (h *Handler) guarded(g *Guard) {
+	if g.Blocked() {
+		g.unwatchLeak()
+		g.release(0, false)
+		g.exitLocal(nil)
+		return
+	}
+	if !g.watched && g.localEntry.Load() != nil {
+		g.watchLeak(h.leakTimeout)
+	}
+	if h.autoEnd {
+		g.autoEnd = context.AfterFunc(g.ctx, func() { g.end(g.Unknown, nil) })
+	}
+	track(g)
+	setFinalizer(g)
+}
+
+// NewGuard returns a guard which hasn't been checked yet, any attr are added to
+// its metrics (on top of the handler, guard, feature, and reason attributes)
 func (h *Handler) NewGuard(ctx context.Context, span trace.Span, attr []attribute.KeyValue, err error) *Guard {
-	attr = append(attr, customerIdKey.String(global.GetCustomerID()))
+	g := h.newGuard(ctx, span, &hubv1.GetTokenLeaseRequest{Selector: &hubv1.GuardFeatureSelector{GuardName: h.guardName}}, err)
+	if len(attr) > 0 {
+		// h.attr is shared by every guard, so copy rather than append to it
+		g.attr = slices.Concat(h.attr, attr)
+		g.sets = nil
+	}
+	return g
+}
+
+func (h *Handler) newGuard(ctx context.Context, span trace.Span, tlr *hubv1.GetTokenLeaseRequest, err error) *Guard {
 	return &Guard{
 		ctx:   ctx,
 		start: time.Time{},
-		tlr:   &hubv1.GetTokenLeaseRequest{Selector: &hubv1.GuardFeatureSelector{GuardName: h.guardName}},
+		tlr:   tlr,
 		meter: global.GetStanzaMeter(),
 		span:  span,
-		attr:  append(h.attr, attr...),
-		err:   err,
+		attr:  h.attr,
+		sets:  h.attrSets,
+		err:   failOpenError(StageInit, err),
+
+		customer:  global.GetCustomerID(),
+		isFailure: h.isFailure,
 
 		Success: GuardSuccess,
 		Failure: GuardFailure,
@@ -129,6 +348,35 @@ func (h *Handler) Tags() *map[string]string {
 	return h.tags
 }
 
+func (h *Handler) Timeout() time.Duration {
+	return h.timeout
+}
+
+// GrpcCode returns the configured gRPC status code for a block reason (if any)
+func (h *Handler) GrpcCode(reason string) (codes.Code, bool) {
+	c, ok := h.grpcCodes[reason]
+	return c, ok
+}
+
+// BlockedHandler returns the configured blocked response writer (nil for the
+// middleware default)
+func (h *Handler) BlockedHandler() func(http.ResponseWriter, *http.Request, *Guard) {
+	return h.blocked
+}
+
+// RetryPolicy returns the handler's retry policy (nil if calls aren't retried)
+func (h *Handler) RetryPolicy() *RetryPolicy {
+	return h.retry
+}
+
+// IsFailure reports whether err should be recorded as a guard Failure
+func (h *Handler) IsFailure(err error) bool {
+	if h.isFailure != nil {
+		return h.isFailure(err)
+	}
+	return err != nil
+}
+
 // OTEL Helper Functions //
 func (h *Handler) Tracer() trace.Tracer {
 	return *global.GetStanzaTracer()
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+)
+
+// benchHandler returns a handler for a guard with the given config, served by
+// a fake hub
+func benchHandler(b *testing.B, guard string, gc *hubv1.GuardConfig, o Options) *Handler {
+	hub, err := hubtest.Start()
+	if err != nil {
+		b.Fatal(err)
+	}
+	hub.SetGuardConfig(guard, gc)
+	h, err := NewHandlerWithOptions(guard, o)
+	if err != nil {
+		b.Fatal(err)
+	}
+	g := h.Guard(context.Background(), nil, nil) // fetch the guard config
+	g.End(g.Success)
+	return h
+}
+
+func benchGuard(b *testing.B, h *Handler, tokens []string) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g := h.Guard(ctx, nil, tokens)
+		g.End(g.Success)
+	}
+}
+
+func BenchmarkGuard(b *testing.B) {
+	b.Run("disabled", func(b *testing.B) {
+		h := benchHandler(b, "bench-disabled", &hubv1.GuardConfig{}, Options{})
+		benchGuard(b, h, nil)
+	})
+	b.Run("fast_path", func(b *testing.B) {
+		h := benchHandler(b, "bench-fast-path", &hubv1.GuardConfig{}, Options{FastPath: true})
+		benchGuard(b, h, nil)
+	})
+	b.Run("handler_per_call", func(b *testing.B) {
+		// like the stanza helpers with options, sharing the guard's attribute cache
+		benchHandler(b, "bench-per-call", &hubv1.GuardConfig{}, Options{})
+		ctx := context.Background()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			h, _ := NewHandlerWithOptions("bench-per-call", Options{})
+			g := h.Guard(ctx, nil, nil)
+			g.End(g.Success)
+		}
+	})
+	b.Run("quota", func(b *testing.B) {
+		h := benchHandler(b, "bench-quota", &hubv1.GuardConfig{CheckQuota: true}, Options{})
+		benchGuard(b, h, nil)
+	})
+	b.Run("blocked", func(b *testing.B) {
+		block := CheckFunc("bench", func(ctx context.Context, g *Guard) CheckResult {
+			return CheckResult{Decision: CheckBlock}
+		})
+		h := benchHandler(b, "bench-blocked", &hubv1.GuardConfig{},
+			Options{Checks: append(DefaultChecks(), block)})
+		benchGuard(b, h, nil)
+	})
+}
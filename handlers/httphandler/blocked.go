@@ -0,0 +1,111 @@
+package httphandler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/logging"
+
+	"github.com/alibaba/sentinel-golang/core/base"
+)
+
+// Blocked is the JSON body written by BlockedJSON
+type Blocked struct {
+	Guard      string `json:"guard"`
+	Feature    string `json:"feature,omitempty"`
+	Reason     string `json:"reason"`
+	Message    string `json:"message"`
+	RetryAfter string `json:"retry_after,omitempty"` // seconds
+}
+
+// Problem is the RFC 7807 problem details body written by BlockedProblem
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance,omitempty"`
+
+	// extension members
+	Guard   string `json:"guard"`
+	Feature string `json:"feature,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// NewBlocked returns the BlockedJSON body for a blocked guard
+func NewBlocked(guard *handlers.Guard) Blocked {
+	return Blocked{
+		Guard:      guard.GuardName(),
+		Feature:    guard.FeatureName(),
+		Reason:     guard.BlockReason(),
+		Message:    guard.BlockMessage(),
+		RetryAfter: RetryAfter(guard),
+	}
+}
+
+// NewProblem returns the BlockedProblem body for a blocked guard, instance
+// should identify the request (usually the request path)
+func NewProblem(guard *handlers.Guard, instance string) Problem {
+	status := BlockedStatusCode(guard)
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   guard.BlockMessage(),
+		Instance: instance,
+		Guard:    guard.GuardName(),
+		Feature:  guard.FeatureName(),
+		Reason:   guard.BlockReason(),
+	}
+}
+
+// BlockedStatusCode returns the HTTP status code for a blocked guard, chosen by
+// block reason: 401 for token failures, 503 for local circuit breaker and
+// system protection rules, and 429 for everything else.
+func BlockedStatusCode(guard *handlers.Guard) int {
+	if err := guard.BlockError(); err != nil {
+		var lbe *handlers.LocalBlockedError
+		if errors.Is(err, handlers.ErrInvalidToken) {
+			return http.StatusUnauthorized
+		}
+		if errors.As(err, &lbe) && lbe.Block != nil {
+			switch lbe.Block.BlockType() {
+			case base.BlockTypeCircuitBreaking, base.BlockTypeSystemFlow:
+				return http.StatusServiceUnavailable
+			}
+		}
+	}
+	return http.StatusTooManyRequests
+}
+
+// BlockedText writes the block message as plain text (the default BlockedHandler)
+func BlockedText(w http.ResponseWriter, r *http.Request, guard *handlers.Guard) {
+	writeBlockedHeader(w, guard, "text/plain; charset=utf-8", BlockedStatusCode(guard))
+	w.Write([]byte(guard.BlockMessage()))
+}
+
+// BlockedJSON writes a JSON body (see Blocked)
+func BlockedJSON(w http.ResponseWriter, r *http.Request, guard *handlers.Guard) {
+	writeBlockedHeader(w, guard, "application/json", BlockedStatusCode(guard))
+	if err := json.NewEncoder(w).Encode(NewBlocked(guard)); err != nil {
+		logging.Error(err)
+	}
+}
+
+// BlockedProblem writes an RFC 7807 application/problem+json body (see Problem)
+func BlockedProblem(w http.ResponseWriter, r *http.Request, guard *handlers.Guard) {
+	writeBlockedHeader(w, guard, "application/problem+json", BlockedStatusCode(guard))
+	if err := json.NewEncoder(w).Encode(NewProblem(guard, r.URL.Path)); err != nil {
+		logging.Error(err)
+	}
+}
+
+func writeBlockedHeader(w http.ResponseWriter, guard *handlers.Guard, contentType string, status int) {
+	w.Header().Set("Content-Type", contentType)
+	if ra := RetryAfter(guard); ra != "" {
+		w.Header().Set("Retry-After", ra)
+	}
+	w.WriteHeader(status)
+}
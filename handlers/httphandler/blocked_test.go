@@ -0,0 +1,187 @@
+package httphandler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
+)
+
+// serve sends a request to next guarded by h, returning the response
+func serve(h *InboundHandler, token string, next http.HandlerFunc) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/blocked", nil)
+	if token != "" {
+		r.Header.Set("X-Stanza-Token", token)
+	}
+	w := httptest.NewRecorder()
+	h.GuardHandler(next).ServeHTTP(w, r)
+	return w
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func failed(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func TestBlockedStatusCode(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		gc      *hubv1.GuardConfig
+		opts    handlers.Options
+		token   string
+		quota   bool                                                // hub has no quota left
+		prepare func(t *testing.T, h *InboundHandler, guard string) // gets the guard to block locally
+		status  int
+		message string
+		retry   bool // Retry-After header
+	}{
+		{
+			name:    "token",
+			gc:      &hubv1.GuardConfig{ValidateIngressTokens: true},
+			token:   hubtest.INVALID_TOKEN,
+			status:  http.StatusUnauthorized,
+			message: "Invalid or expired X-Stanza-Token.",
+		},
+		{
+			name:    "quota",
+			gc:      &hubv1.GuardConfig{CheckQuota: true},
+			quota:   true,
+			status:  http.StatusTooManyRequests,
+			message: "Stanza quota exhausted. Please try again later.",
+			retry:   true,
+		},
+		{
+			name: "concurrency",
+			gc:   &hubv1.GuardConfig{},
+			opts: handlers.Options{ConcurrencyLimit: 1},
+			prepare: func(t *testing.T, h *InboundHandler, guard string) {
+				held := h.Guard(context.Background(), nil, nil)
+				assert.True(t, held.Allowed())
+				t.Cleanup(func() { held.End(held.Success) })
+			},
+			status:  http.StatusTooManyRequests,
+			message: "Stanza concurrency limit reached. Please try again later.",
+			retry:   true,
+		},
+		{
+			name: "circuit_breaker",
+			gc:   &hubv1.GuardConfig{},
+			prepare: func(t *testing.T, h *InboundHandler, guard string) {
+				_, err := circuitbreaker.LoadRulesOfResource(guard, []*circuitbreaker.Rule{{
+					Resource:         guard,
+					Strategy:         circuitbreaker.ErrorCount,
+					RetryTimeoutMs:   60000,
+					MinRequestAmount: 1,
+					StatIntervalMs:   60000,
+					Threshold:        1,
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { circuitbreaker.ClearRulesOfResource(guard) })
+				assert.Equal(t, http.StatusInternalServerError, serve(h, "", failed).Code)
+			},
+			status: http.StatusServiceUnavailable,
+			retry:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := "blocked-status-code-" + tt.name
+			hub.SetGuardConfig(guard, tt.gc)
+			hub.SetBlocked(guard, tt.quota)
+			h, err := NewInboundHandlerWithOptions(guard, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(t, h, guard)
+			}
+
+			// BlockedText is the default BlockedHandler
+			w := serve(h, tt.token, ok)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+			assert.NotEqual(t, "ok", w.Body.String())
+			if tt.message != "" {
+				assert.Equal(t, tt.message, w.Body.String())
+			}
+			assert.Equal(t, tt.retry, w.Header().Get("Retry-After") != "")
+		})
+	}
+}
+
+func TestBlockedHandler(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const guard = "blocked-handler"
+	hub.SetGuardConfig(guard, &hubv1.GuardConfig{CheckQuota: true})
+	hub.SetBlocked(guard, true)
+	handler := func(t *testing.T, blocked func(http.ResponseWriter, *http.Request, *handlers.Guard)) *InboundHandler {
+		h, err := NewInboundHandlerWithOptions(guard, handlers.Options{BlockedHandler: blocked})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	t.Run("json", func(t *testing.T) {
+		w := serve(handler(t, BlockedJSON), "", ok)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var body Blocked
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, Blocked{
+			Guard:      guard,
+			Reason:     handlers.ReasonQuotaBlocked,
+			Message:    "Stanza quota exhausted. Please try again later.",
+			RetryAfter: w.Header().Get("Retry-After"),
+		}, body)
+		assert.NotEmpty(t, body.RetryAfter)
+	})
+
+	t.Run("problem", func(t *testing.T) {
+		w := serve(handler(t, BlockedProblem), "", ok)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		var body Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, Problem{
+			Type:     "about:blank",
+			Title:    "Too Many Requests",
+			Status:   http.StatusTooManyRequests,
+			Detail:   "Stanza quota exhausted. Please try again later.",
+			Instance: "/blocked",
+			Guard:    guard,
+			Reason:   handlers.ReasonQuotaBlocked,
+		}, body)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		// like serving a degraded response from a cache
+		w := serve(handler(t, func(w http.ResponseWriter, r *http.Request, g *handlers.Guard) {
+			w.Header().Set("X-Blocked-Reason", g.BlockReason())
+			w.Write([]byte("cached"))
+		}), "", ok)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handlers.ReasonQuotaBlocked, w.Header().Get("X-Blocked-Reason"))
+		assert.Equal(t, "cached", w.Body.String())
+	})
+}
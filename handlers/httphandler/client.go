@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/StanzaSystems/sdk-go/global"
 	"github.com/StanzaSystems/sdk-go/handlers"
@@ -23,7 +25,17 @@ type OutboundHandler struct {
 
 // NewOutboundHandler returns a new OutboundHandler
 func NewOutboundHandler(gn string, fn *string, pb *int32, dw *float32, kv *map[string]string) (*OutboundHandler, error) {
-	h, err := handlers.NewOutboundHandler(gn, fn, pb, dw, kv)
+	return NewOutboundHandlerWithOptions(gn, handlers.Options{
+		Feature:       fn,
+		PriorityBoost: pb,
+		DefaultWeight: dw,
+		Tags:          kv,
+	})
+}
+
+// NewOutboundHandlerWithOptions returns a new OutboundHandler configured with handlers.Options
+func NewOutboundHandlerWithOptions(gn string, o handlers.Options) (*OutboundHandler, error) {
+	h, err := handlers.NewOutboundHandlerWithOptions(gn, o)
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +52,10 @@ func (h *OutboundHandler) Post(ctx context.Context, url string, body io.Reader)
 	return h.Request(ctx, http.MethodPost, url, body)
 }
 
-// Request wraps a HTTP request of the given HTTP method
+// Request wraps a HTTP request of the given HTTP method. If the guard blocks,
+// a synthetic 429 response is returned along with a *handlers.BlockedError.
+// With a retry policy (see handlers.RetryPolicy), failed attempts of
+// idempotent requests are retried (and hedged), each through the guard again.
 func (h *OutboundHandler) Request(ctx context.Context, httpMethod, url string, body io.Reader) (*http.Response, error) {
 	if req, err := http.NewRequestWithContext(ctx, httpMethod, url, body); err != nil {
 		h.FailOpen(ctx)
@@ -54,50 +69,144 @@ func (h *OutboundHandler) Request(ctx context.Context, httpMethod, url string, b
 		)
 		defer span.End()
 		h.Propagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+		ctx = h.ExtractTags(ctx, handlers.HTTPTagSource(req))
 
-		guard := h.Guard(ctx, span, nil)
+		try, cancel := handlers.Retry(ctx, h.Handler, h.idempotent(req), true,
+			func(ctx context.Context, attempt int) handlers.Try[*http.Response] {
+				return h.attempt(ctx, span, req, attempt)
+			},
+			discardResponse,
+		)
+		if cancel != nil {
+			if try.Value != nil && try.Value.Body != nil {
+				try.Value.Body = &cancelBody{ReadCloser: try.Value.Body, cancel: cancel}
+			} else {
+				cancel()
+			}
+		}
+		return try.Value, try.Err
+	}
+}
 
-		// Stanza Blocked
-		if guard.Blocked() {
-			span.SetStatus(codes.Error, guard.BlockMessage())
-			return &http.Response{
+// attempt makes one guarded attempt of req
+func (h *OutboundHandler) attempt(ctx context.Context, span trace.Span, req *http.Request, attempt int) handlers.Try[*http.Response] {
+	guard := h.Guard(ctx, span, nil)
+
+	// Stanza Blocked
+	if guard.Blocked() {
+		span.SetStatus(codes.Error, guard.BlockMessage())
+		header := http.Header{}
+		if ra := RetryAfter(guard); ra != "" {
+			header.Set("Retry-After", ra)
+		}
+		return handlers.Try[*http.Response]{
+			Value: &http.Response{
 				Status:     fmt.Sprintf("%d Too Many Request", http.StatusTooManyRequests),
 				StatusCode: http.StatusTooManyRequests,
 				Request:    req,
 				Body:       http.NoBody,
-				Header:     http.Header{
-					// TODO: Add retry-after header
-				},
-			}, guard.Error()
+				Header:     header,
+			},
+			Err:     guard.BlockError(),
+			Blocked: true,
 		}
+	}
 
-		// Stanza Allowed
-		if guard.Token() != "" {
-			req.Header.Add("X-Stanza-Token", guard.Token())
-		}
-		if req.Header.Get("User-Agent") == "" {
-			req.Header.Set("User-Agent", global.UserAgent())
+	// Stanza Allowed
+	// every attempt gets its own copy (hedged attempts run concurrently), and
+	// retries a fresh body
+	req = req.Clone(ctx)
+	if attempt > 1 && req.GetBody != nil {
+		req.Body, _ = req.GetBody()
+	}
+	if guard.Token() != "" {
+		req.Header.Add("X-Stanza-Token", guard.Token())
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", global.UserAgent())
+	}
+	if ctx.Value(keys.OutboundHeadersKey) != nil {
+		for k, v := range ctx.Value(keys.OutboundHeadersKey).(http.Header) {
+			req.Header.Set(k, v[0])
 		}
-		if ctx.Value(keys.OutboundHeadersKey) != nil {
-			for k, v := range ctx.Value(keys.OutboundHeadersKey).(http.Header) {
-				req.Header.Set(k, v[0])
-			}
+	}
+	httpClient := &http.Client{Transport: http.DefaultTransport}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		guard.EndWith(handlers.Outcome{Status: guard.Failure, Err: err})
+		return handlers.Try[*http.Response]{
+			Value:     resp,
+			Err:       err, // TODO: multierr with guard.Error()?
+			Retryable: ctx.Err() == nil,
 		}
-		httpClient := &http.Client{Transport: http.DefaultTransport}
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, err.Error())
-			guard.End(guard.Failure)
-			return resp, err // TODO: multierr with guard.Error()?
-		} else {
-			span.SetAttributes(
-				semconv.UserAgentOriginal(req.Header.Get("User-Agent")),
-				semconv.HTTPStatusCode(resp.StatusCode),
-			)
-			span.SetStatus(codes.Ok, "OK")
-			guard.End(guard.Success)
-			return resp, guard.Error()
+	} else {
+		span.SetAttributes(
+			semconv.UserAgentOriginal(req.Header.Get("User-Agent")),
+			semconv.HTTPStatusCode(resp.StatusCode),
+		)
+		span.SetStatus(codes.Ok, "OK")
+		guard.EndWith(handlers.Outcome{
+			Status: guard.Success,
+			Code:   resp.StatusCode,
+			Bytes:  resp.ContentLength,
+		})
+		try := handlers.Try[*http.Response]{Value: resp, Err: guard.Error()}
+		if p := h.RetryPolicy(); p != nil && p.RetryableHTTPStatus(resp.StatusCode) {
+			try.Retryable = true
+			try.RetryAfter = retryAfter(resp.Header.Get("Retry-After"))
 		}
+		return try
+	}
+}
+
+// idempotent reports whether req may be retried (and hedged): idempotent
+// methods (RFC 9110), requests with an Idempotency-Key header, and methods
+// declared idempotent by the retry policy. Requests whose body can't be
+// replayed are never retried.
+func (h *OutboundHandler) idempotent(req *http.Request) bool {
+	p := h.RetryPolicy()
+	if p == nil || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || p.Idempotent(req.Method)
+}
+
+// retryAfter parses a Retry-After header (in seconds, or an HTTP date)
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
 	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// discardResponse closes the body of a response which won't be returned
+func discardResponse(resp *http.Response) {
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) // so the connection can be reused
+		resp.Body.Close()
+	}
+}
+
+// cancelBody cancels the context of the attempt which returned it when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
@@ -0,0 +1,73 @@
+package httphandler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboundRetry(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetGuardConfig("retry-client", &hubv1.GuardConfig{CheckQuota: true})
+
+	// fails twice, recording the quota token and body of every attempt
+	var lock sync.Mutex
+	var tokens, bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		tokens = append(tokens, r.Header.Get("X-Stanza-Token"))
+		bodies = append(bodies, string(body))
+		if len(tokens) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	h, err := NewOutboundHandlerWithOptions("retry-client", handlers.Options{
+		Retry: &handlers.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.Request(context.Background(), http.MethodPut, srv.URL, strings.NewReader("payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+
+	// every attempt went through the guard (and got its own quota lease), and
+	// sent the whole body
+	assert.Len(t, tokens, 3)
+	assert.NotEmpty(t, tokens[0])
+	assert.NotEqual(t, tokens[0], tokens[1])
+	assert.NotEqual(t, tokens[1], tokens[2])
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+
+	// POST isn't idempotent, so it isn't retried
+	lock.Lock()
+	tokens = nil
+	lock.Unlock()
+	resp, err = h.Post(context.Background(), srv.URL, strings.NewReader("payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp.Body.Close()
+	assert.Len(t, tokens, 1)
+}
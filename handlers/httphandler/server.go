@@ -3,7 +3,9 @@ package httphandler
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/StanzaSystems/sdk-go/handlers"
 
@@ -21,7 +23,17 @@ type InboundHandler struct {
 
 // NewInboundHandler returns a new InboundHandler
 func NewInboundHandler(gn string, fn *string, pb *int32, dw *float32, kv *map[string]string) (*InboundHandler, error) {
-	h, err := handlers.NewInboundHandler(gn, fn, pb, dw, kv)
+	return NewInboundHandlerWithOptions(gn, handlers.Options{
+		Feature:       fn,
+		PriorityBoost: pb,
+		DefaultWeight: dw,
+		Tags:          kv,
+	})
+}
+
+// NewInboundHandlerWithOptions returns a new InboundHandler configured with handlers.Options
+func NewInboundHandlerWithOptions(gn string, o handlers.Options) (*InboundHandler, error) {
+	h, err := handlers.NewInboundHandlerWithOptions(gn, o)
 	if err != nil {
 		return nil, err
 	}
@@ -33,12 +45,16 @@ func (h *InboundHandler) GuardHandlerFunction(next func(w http.ResponseWriter, r
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, span, tokens := h.Start(r)
 		defer span.End()
+		ctx = h.ExtractTags(ctx, handlers.HTTPTagSource(r))
 
 		guard := h.Guard(ctx, span, tokens)
 		if guard.Blocked() {
 			span.SetStatus(codes.Error, guard.BlockMessage())
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte(guard.BlockMessage()))
+			if blocked := h.BlockedHandler(); blocked != nil {
+				blocked(w, r.WithContext(ctx), guard)
+			} else {
+				BlockedText(w, r, guard)
+			}
 			return
 		}
 
@@ -46,11 +62,11 @@ func (h *InboundHandler) GuardHandlerFunction(next func(w http.ResponseWriter, r
 		code, msg := h.HTTPServerStatus(m.Code)
 		span.SetAttributes(semconv.HTTPStatusCode(m.Code))
 		span.SetStatus(code, msg)
+		outcome := handlers.Outcome{Status: guard.Success, Code: m.Code, Bytes: m.Written}
 		if code == codes.Error {
-			guard.End(guard.Failure)
-		} else {
-			guard.End(guard.Success)
+			outcome.Status = guard.Failure
 		}
+		guard.EndWith(outcome)
 	}
 }
 
@@ -74,6 +90,17 @@ func (h *InboundHandler) Start(r *http.Request) (context.Context, trace.Span, []
 	return ctx, span, r.Header.Values("x-stanza-token")
 }
 
+// RetryAfter formats a guard's suggested retry delay (see Guard.RetryAfter) as
+// a Retry-After header value, in whole seconds rounded up. It returns an empty
+// string if there is no suggested retry delay.
+func RetryAfter(guard *handlers.Guard) string {
+	d := guard.RetryAfter()
+	if d <= 0 {
+		return ""
+	}
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
 // HTTPServerStatus returns a span status code and message for an HTTP status code
 // value returned by a server. Status codes in the 400-499 range are not
 // returned as errors.
@@ -0,0 +1,56 @@
+package httphandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+)
+
+// discardWriter is a reusable http.ResponseWriter, so the benchmarks only
+// count the middleware's allocations
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkInboundHandler(b *testing.B) {
+	bench := func(b *testing.B, guard string, gc *hubv1.GuardConfig, o handlers.Options) {
+		hub, err := hubtest.Start()
+		if err != nil {
+			b.Fatal(err)
+		}
+		hub.SetGuardConfig(guard, gc)
+		h, err := NewInboundHandlerWithOptions(guard, o)
+		if err != nil {
+			b.Fatal(err)
+		}
+		next := h.GuardHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/bench", nil)
+		w := &discardWriter{header: http.Header{}}
+		next.ServeHTTP(w, r) // fetch the guard config
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			next.ServeHTTP(w, r)
+		}
+	}
+	b.Run("disabled", func(b *testing.B) {
+		bench(b, "bench-http-disabled", &hubv1.GuardConfig{}, handlers.Options{})
+	})
+	b.Run("fast_path", func(b *testing.B) {
+		bench(b, "bench-http-fast-path", &hubv1.GuardConfig{}, handlers.Options{FastPath: true})
+	})
+	b.Run("quota", func(b *testing.B) {
+		bench(b, "bench-http-quota", &hubv1.GuardConfig{CheckQuota: true}, handlers.Options{})
+	})
+}
@@ -9,6 +9,25 @@ const (
 	localReason  = "local_reason"
 	tokenReason  = "token_reason"
 	quotaReason  = "quota_reason"
+	priorityBand = "priority_band"
+
+	// reported instead of the hub provided reason when hub rejected our API key
+	configAuthError = "CONFIG_AUTH_ERROR"
+	tokenAuthError  = "TOKEN_AUTH_ERROR"
+	quotaAuthError  = "QUOTA_AUTH_ERROR"
+
+	// reported instead of CONFIG_UNSPECIFIED for guards used before Init
+	configNotInitialized = "CONFIG_NOT_INITIALIZED"
+
+	// reported instead of QUOTA_UNSPECIFIED when shadow mode couldn't tell
+	// whether quota would have been granted (see hub.SimulateQuota)
+	quotaShadowUnknown = "QUOTA_SHADOW_UNKNOWN"
+
+	// reported instead of LOCAL_BLOCKED when an in-process concurrency limit blocked
+	localConcurrencyLimited = "LOCAL_CONCURRENCY_LIMITED"
+
+	// reported instead of LOCAL_BLOCKED when low priority traffic was shed
+	localPriorityShed = "LOCAL_PRIORITY_SHED"
 )
 
 var (
@@ -20,9 +39,15 @@ var (
 	priorityBoostKey = attribute.Key("priority_boost")
 	serviceKey       = attribute.Key("service")
 	errorKey         = attribute.Key("error")
+	errorClassKey    = attribute.Key("error_class")
+	statusCodeKey    = attribute.Key("status_code")
+	bytesKey         = attribute.Key("bytes")
+	attemptKey       = attribute.Key("attempt")
 	modeKey          = attribute.Key("mode")
+	shadowKey        = attribute.Key("shadow")
 	configReasonKey  = attribute.Key(configReason)
 	localReasonKey   = attribute.Key(localReason)
 	tokenReasonKey   = attribute.Key(tokenReason)
 	quotaReasonKey   = attribute.Key(quotaReason)
+	priorityBandKey  = attribute.Key(priorityBand)
 )
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/StanzaSystems/sdk-go/hub"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/status"
+)
+
+// Outcome describes how the guarded work went, see EndWith
+type Outcome struct {
+	// Success, Failure, or Unknown, if left Unknown (the zero value) it is
+	// Failure if Err is one (see Options.IsFailure) and Success otherwise
+	Status int
+
+	Err        error                // recorded by class (see ErrorClass), along with its message on the span
+	Code       int                  // HTTP status or gRPC code of the response (0 isn't recorded)
+	Attributes []attribute.KeyValue // added to the End metrics and span event, keep their cardinality low
+	Bytes      int64                // response size (0 if unknown)
+
+	// actual weight (cost) of the request, when it is only known after the
+	// request ran, reported to Stanza Hub to correct the quota consumed (0
+	// keeps the weight quota was leased with)
+	Weight float32
+}
+
+// EndWith records the outcome of the guarded work like End does, along with
+// its error class, status code, and attributes (on the End metrics and as a
+// span event), response size, and actual weight. Only the first call to End
+// or EndWith has any effect.
+func (g *Guard) EndWith(o Outcome) {
+	if g.autoEnd != nil {
+		g.autoEnd()
+	}
+	g.end(g.outcomeStatus(&o), &o)
+}
+
+func (g *Guard) outcomeStatus(o *Outcome) int {
+	if o.Status != g.Unknown {
+		return o.Status
+	}
+	failure := o.Err != nil
+	if g.isFailure != nil {
+		failure = g.isFailure(o.Err)
+	}
+	if failure {
+		return g.Failure
+	}
+	return g.Success
+}
+
+// outcome records the outcome's response size, span event, and weight,
+// returning the attribute set for the rest of the End metrics
+func (g *Guard) outcome(o *Outcome) *attrSet {
+	key := g.attrKey(false)
+	key.code = o.Code
+	key.errorClass = ErrorClass(o.Err)
+	as := g.cachedAttrs(key, o.Attributes)
+
+	if o.Bytes > 0 {
+		g.meter.AllowedBytes.Record(g.ctx, o.Bytes, as.record...)
+	}
+	if g.span != nil && g.span.IsRecording() {
+		attr := as.set.ToSlice()
+		if o.Err != nil {
+			attr = append(attr, errorKey.String(o.Err.Error()))
+		}
+		if o.Bytes > 0 {
+			attr = append(attr, bytesKey.Int64(o.Bytes))
+		}
+		g.span.AddEvent("Stanza ended", trace.WithAttributes(attr...))
+	}
+	if o.Weight > 0 && g.quotaToken != "" && !g.shadow {
+		hub.CorrectWeight(g.quotaToken, o.Weight)
+	}
+	return as
+}
+
+// ErrorClass returns a low cardinality class of err (its gRPC code, a network
+// or context error, or its type), for breaking failures down by cause
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.Is(err, ErrBlocked):
+		return "blocked"
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code().String()
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return fmt.Sprintf("%T", err)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, "", ErrorClass(nil))
+	assert.Equal(t, "canceled", ErrorClass(fmt.Errorf("call: %w", context.Canceled)))
+	assert.Equal(t, "deadline_exceeded", ErrorClass(context.DeadlineExceeded))
+	assert.Equal(t, "blocked", ErrorClass(&BlockedError{Err: ErrQuotaExhausted}))
+	assert.Equal(t, "Unavailable", ErrorClass(status.Error(codes.Unavailable, "down")))
+	assert.Equal(t, "*fs.PathError", ErrorClass(&fs.PathError{Op: "open", Err: fs.ErrNotExist}))
+}
+
+func TestEndWith(t *testing.T) {
+	h := &Handler{guardName: "outcome-guard", attrSets: &attrCache{}}
+	g := h.NewGuard(context.Background(), nil, nil, nil)
+	assert.Equal(t, g.Success, g.outcomeStatus(&Outcome{}))
+	assert.Equal(t, g.Failure, g.outcomeStatus(&Outcome{Err: errors.New("failed")}))
+
+	// errors the handler doesn't consider failures are successes
+	h.isFailure = func(err error) bool { return !errors.Is(err, context.Canceled) }
+	g = h.NewGuard(context.Background(), nil, nil, nil)
+	assert.Equal(t, g.Success, g.outcomeStatus(&Outcome{Err: context.Canceled}))
+
+	// the outcome is part of the End attributes, cached unless it has extra
+	// attributes
+	o := &Outcome{Err: status.Error(codes.Internal, "oops"), Code: int(codes.Internal)}
+	as := g.outcome(o)
+	class, _ := as.set.Value(errorClassKey)
+	code, _ := as.set.Value(statusCodeKey)
+	assert.Equal(t, "Internal", class.AsString())
+	assert.Equal(t, int64(codes.Internal), code.AsInt64())
+	assert.Same(t, as, g.outcome(o))
+	assert.NotSame(t, as, g.attrs(false))
+
+	o.Attributes = []attribute.KeyValue{attribute.String("route", "/quote")}
+	route, _ := g.outcome(o).set.Value("route")
+	assert.Equal(t, "/quote", route.AsString())
+}
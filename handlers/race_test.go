@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/global"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConcurrentGuards runs guards of every kind while their configs, the
+// service config, and OTEL change underneath them. It is meant to be run with
+// the race detector (go test -race).
+func TestConcurrentGuards(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetCollector(hub.Addr())
+	t.Cleanup(func() { hub.SetCollector("") })
+
+	const (
+		workers    = 4
+		iterations = 200
+	)
+	options := map[string]Options{
+		"race-quota":       {},
+		"race-tokens":      {},
+		"race-concurrency": {ConcurrencyLimit: workers},
+		"race-fast-path":   {FastPath: true},
+		"race-shadow":      {Shadow: true},
+		"race-auto-end":    {AutoEnd: true},
+	}
+	configs := []*hubv1.GuardConfig{
+		{},
+		{CheckQuota: true},
+		{ValidateIngressTokens: true},
+		{CheckQuota: true, ValidateIngressTokens: true},
+	}
+	handlers := map[string]*Handler{}
+	for guard, o := range options {
+		hub.SetGuardConfig(guard, configs[0])
+		h, err := NewHandlerWithOptions(guard, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handlers[guard] = h
+	}
+
+	ctx := context.Background()
+
+	// keep changing everything guards read (at least a few times, guards are
+	// quick when their leases are cached)
+	stop := make(chan struct{})
+	changed := make(chan struct{})
+	go func() {
+		defer close(changed)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				if i >= 20 {
+					return
+				}
+			default:
+			}
+			for guard := range options {
+				hub.SetGuardConfig(guard, configs[i%len(configs)])
+			}
+			hub.SetBlocked("race-quota", i%2 == 1)
+			global.GetGuardConfigs(ctx, true)
+			global.GetServiceConfig(ctx, true)
+			if i%10 == 0 {
+				global.OtelStartup(ctx, true)
+			}
+			global.GetStanzaMeter()
+			global.GetStanzaTracer()
+			global.GetHealth()
+			global.GetCustomerID()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var guards, unexpected atomic.Int64
+	for guard, h := range handlers {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(guard string, h *Handler) {
+				defer wg.Done()
+				for i := 0; i < iterations; i++ {
+					gctx, gcancel := context.WithCancel(ctx)
+					g := h.Guard(gctx, nil, []string{"race-token"})
+					guards.Add(1)
+					// only the quota guard is ever out of quota
+					if !g.Allowed() && guard != "race-quota" {
+						unexpected.Add(1)
+					}
+					if !h.autoEnd {
+						g.End(g.Success)
+					}
+					gcancel()
+				}
+			}(guard, h)
+		}
+	}
+	wg.Wait()
+	close(stop)
+	<-changed
+
+	assert.Equal(t, int64(len(handlers)*workers*iterations), guards.Load())
+	assert.Zero(t, unexpected.Load())
+	assert.NotZero(t, hub.Calls("GetGuardConfig"))
+	assert.NotZero(t, hub.Calls("GetBearerToken"))
+	assert.NotNil(t, global.GetStanzaMeter())
+
+	// every concurrency slot was freed
+	g := handlers["race-concurrency"].Guard(ctx, nil, []string{"race-token"})
+	assert.True(t, g.Allowed())
+	g.End(g.Success)
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/StanzaSystems/sdk-go/logging"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	DEFAULT_RETRY_BACKOFF     = 100 * time.Millisecond
+	DEFAULT_RETRY_MAX_BACKOFF = 2 * time.Second
+
+	// Fraction of a guard's calls which may be retried (or hedged)
+	DEFAULT_RETRY_BUDGET = 0.1
+
+	// Most retries a guard's budget holds, so a quiet guard can still retry a
+	// few calls but a burst of failures can't turn into a burst of retries
+	RETRY_BUDGET_BURST = 10
+)
+
+var (
+	// HTTP status codes and gRPC codes retried by default
+	DefaultRetryHTTPStatusCodes = []int{502, 503, 504}
+	DefaultRetryGrpcCodes       = []codes.Code{codes.Unavailable}
+
+	// retry budgets, keyed by guard name
+	sharedBudgets     = map[string]*retryBudget{}
+	sharedBudgetsLock = &sync.Mutex{}
+)
+
+// RetryPolicy retries (and optionally hedges) outbound calls. Every attempt
+// goes through the guard again, so retries are subject to (and consume) quota
+// just like first attempts. Streaming gRPC calls are never retried.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts per call, including the first one (and any hedges)
+	Backoff     time.Duration // delay before the first retry, doubled for each one after it, with jitter (0 for DEFAULT_RETRY_BACKOFF)
+	MaxBackoff  time.Duration // longest delay between attempts, longer Retry-After responses aren't retried (0 for DEFAULT_RETRY_MAX_BACKOFF)
+
+	HTTPStatusCodes []int        // responses to retry (default: DefaultRetryHTTPStatusCodes), transport errors are always retried
+	GrpcCodes       []codes.Code // errors to retry (default: DefaultRetryGrpcCodes)
+
+	// Only idempotent calls are retried: HTTP requests with an idempotent
+	// method (or an Idempotency-Key header), and these HTTP methods or full gRPC
+	// method names ("/package.Service/Method")
+	IdempotentMethods []string
+
+	// retry non-idempotent calls too (they are never hedged)
+	RetryNonIdempotent bool
+
+	// caps retries and hedges to this fraction (at most 1) of the guard's
+	// calls (0 for DEFAULT_RETRY_BUDGET), shared by every handler for the guard
+	Budget float64
+
+	// send another attempt of an idempotent call if none has completed within
+	// this long (0 disables hedging), the first attempt to complete wins
+	HedgeAfter time.Duration
+}
+
+// RetryableHTTPStatus reports whether a response with the status code is retried
+func (p *RetryPolicy) RetryableHTTPStatus(code int) bool {
+	if p.HTTPStatusCodes == nil {
+		return slices.Contains(DefaultRetryHTTPStatusCodes, code)
+	}
+	return slices.Contains(p.HTTPStatusCodes, code)
+}
+
+// RetryableGrpcCode reports whether an error with the gRPC code is retried
+func (p *RetryPolicy) RetryableGrpcCode(code codes.Code) bool {
+	if p.GrpcCodes == nil {
+		return slices.Contains(DefaultRetryGrpcCodes, code)
+	}
+	return slices.Contains(p.GrpcCodes, code)
+}
+
+// Idempotent reports whether method was declared idempotent
+func (p *RetryPolicy) Idempotent(method string) bool {
+	return slices.Contains(p.IdempotentMethods, method)
+}
+
+// delay returns how long to wait before the given retry (1 for the first), or
+// false if the call shouldn't be retried (the server asked us to wait longer
+// than MaxBackoff)
+func (p *RetryPolicy) delay(retry int, retryAfter time.Duration) (time.Duration, bool) {
+	backoff, maxBackoff := p.Backoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = DEFAULT_RETRY_BACKOFF
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DEFAULT_RETRY_MAX_BACKOFF
+	}
+	if retryAfter > maxBackoff {
+		return 0, false
+	}
+	for i := 1; i < retry && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+	// equal jitter, so concurrent callers spread out but still back off
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	return max(backoff, retryAfter), true
+}
+
+// retryBudget lets a guard retry a fraction of its calls: every call deposits
+// that fraction of a retry, every retry (or hedge) withdraws a whole one
+type retryBudget struct {
+	lock    sync.Mutex
+	ratio   float64
+	balance float64
+}
+
+// sharedBudget returns the retry budget for a guard, creating it if there
+// isn't one yet (the first handler's ratio wins, like with sharedLimiter)
+func sharedBudget(guard string, ratio float64) *retryBudget {
+	if ratio <= 0 {
+		ratio = DEFAULT_RETRY_BUDGET
+	}
+	sharedBudgetsLock.Lock()
+	defer sharedBudgetsLock.Unlock()
+	b, ok := sharedBudgets[guard]
+	if !ok {
+		b = &retryBudget{ratio: ratio, balance: RETRY_BUDGET_BURST}
+		sharedBudgets[guard] = b
+	}
+	return b
+}
+
+func (b *retryBudget) deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.balance = min(b.balance+b.ratio, RETRY_BUDGET_BURST)
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.balance < 1 {
+		return false
+	}
+	b.balance -= 1
+	return true
+}
+
+// Try is the result of one attempt of a retried call
+type Try[T any] struct {
+	Value      T
+	Err        error
+	Retryable  bool          // the attempt failed in a way worth retrying
+	RetryAfter time.Duration // how long the server asked us to wait (if it did)
+	Blocked    bool          // the attempt's guard blocked it
+}
+
+type attempt[T any] struct {
+	Try[T]
+	n      int
+	cancel context.CancelFunc
+}
+
+// Retry calls call under the handler's RetryPolicy (once, if it has none or
+// the call isn't idempotent), hedging idempotent calls only if hedge is true
+// (callers whose attempts share state can't run them concurrently), returning the first attempt which didn't fail
+// in a retryable way, or the last one. Blocked attempts aren't retried, and
+// a blocked retry returns the attempt before it. discard (if not nil) is
+// called with the values of every other attempt. The returned cancel func
+// (nil without a policy) cancels the context of the returned attempt, call
+// it once done with its value.
+func Retry[T any](ctx context.Context, h *Handler, idempotent, hedge bool, call func(ctx context.Context, attempt int) Try[T], discard func(T)) (Try[T], context.CancelFunc) {
+	p := h.retry
+	if p == nil {
+		return call(ctx, 1), nil
+	}
+	h.retryBudget.deposit()
+	if p.MaxAttempts <= 1 || (!idempotent && !p.RetryNonIdempotent) {
+		return call(ctx, 1), nil
+	}
+
+	results := make(chan *attempt[T], p.MaxAttempts)
+	cancels := make([]context.CancelFunc, p.MaxAttempts+1) // by attempt
+	launch := func(n int) {
+		actx, cancel := context.WithCancel(ctx)
+		cancels[n] = cancel
+		go func() {
+			results <- &attempt[T]{Try: call(actx, n), n: n, cancel: cancel}
+		}()
+	}
+	drop := func(a *attempt[T]) {
+		if a == nil {
+			return
+		}
+		if discard != nil {
+			discard(a.Value)
+		}
+		a.cancel()
+	}
+
+	var hedgeTimer *time.Timer
+	if idempotent && hedge && p.HedgeAfter > 0 {
+		hedgeTimer = time.NewTimer(p.HedgeAfter)
+		defer hedgeTimer.Stop()
+	}
+	hedgeC := func() <-chan time.Time {
+		if hedgeTimer == nil {
+			return nil
+		}
+		return hedgeTimer.C
+	}
+
+	launch(1)
+	attempts, inflight, retries := 1, 1, 0
+	var last, blocked *attempt[T] // last retryable failure, first blocked attempt
+	var retry <-chan time.Time
+	done := ctx.Done()
+	finish := func(a *attempt[T]) (Try[T], context.CancelFunc) {
+		if a != last {
+			drop(last)
+		}
+		if a != blocked {
+			drop(blocked)
+		}
+		// cancel the remaining attempts, and let them finish in the background
+		for n, cancel := range cancels {
+			if cancel != nil && n != a.n {
+				cancel()
+			}
+		}
+		go func(n int) {
+			for ; n > 0; n-- {
+				drop(<-results)
+			}
+		}(inflight)
+		return a.Try, a.cancel
+	}
+	next := func(kind string) bool {
+		if attempts >= p.MaxAttempts || !h.retryBudget.withdraw() {
+			return false
+		}
+		attempts, inflight = attempts+1, inflight+1
+		trace.SpanFromContext(ctx).AddEvent("Stanza "+kind,
+			trace.WithAttributes(guardKey.String(h.guardName), attemptKey.Int(attempts)))
+		logging.Debug("Stanza "+kind, "guard", h.guardName, "attempt", attempts)
+		launch(attempts)
+		return true
+	}
+
+	for {
+		select {
+		case a := <-results:
+			inflight--
+			switch {
+			case a.Blocked:
+				if blocked == nil {
+					blocked = a
+				} else {
+					drop(a)
+				}
+			case a.Retryable && ctx.Err() == nil:
+				drop(last)
+				last = a
+				if retry == nil && attempts < p.MaxAttempts {
+					retries++
+					if d, ok := p.delay(retries, a.RetryAfter); ok {
+						retry = time.After(d)
+					}
+				}
+			default:
+				return finish(a) // succeeded, or not worth retrying
+			}
+		case <-retry:
+			retry = nil
+			next("retry")
+		case <-hedgeC():
+			if next("hedge") {
+				hedgeTimer.Reset(p.HedgeAfter)
+			}
+		case <-done:
+			done, retry = nil, nil // in-flight attempts see the cancellation too
+		}
+		if inflight == 0 && retry == nil {
+			if last != nil {
+				return finish(last)
+			}
+			return finish(blocked)
+		}
+	}
+}
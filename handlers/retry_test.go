@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func retryHandler(p RetryPolicy) *Handler {
+	return &Handler{
+		guardName:   "retry-guard",
+		retry:       &p,
+		retryBudget: &retryBudget{ratio: DEFAULT_RETRY_BUDGET, balance: RETRY_BUDGET_BURST},
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	errUnavailable := errors.New("unavailable")
+	failing := func(failures int, calls *atomic.Int32) func(context.Context, int) Try[int] {
+		return func(ctx context.Context, attempt int) Try[int] {
+			calls.Add(1)
+			if attempt <= failures {
+				return Try[int]{Value: attempt, Err: errUnavailable, Retryable: true}
+			}
+			return Try[int]{Value: attempt}
+		}
+	}
+
+	// retried until an attempt succeeds
+	var calls atomic.Int32
+	h := retryHandler(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	try, cancel := Retry(ctx, h, true, true, failing(2, &calls), nil)
+	cancel()
+	assert.NoError(t, try.Err)
+	assert.Equal(t, 3, try.Value)
+
+	// or until there are no attempts left
+	calls.Store(0)
+	try, cancel = Retry(ctx, h, true, true, failing(5, &calls), nil)
+	cancel()
+	assert.ErrorIs(t, try.Err, errUnavailable)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// non-idempotent calls aren't retried (by default)
+	calls.Store(0)
+	try, _ = Retry(ctx, h, false, true, failing(1, &calls), nil)
+	assert.ErrorIs(t, try.Err, errUnavailable)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// nor are calls once the budget is spent
+	calls.Store(0)
+	h.retryBudget.balance = 0
+	try, cancel = Retry(ctx, h, true, true, failing(1, &calls), nil)
+	cancel()
+	assert.ErrorIs(t, try.Err, errUnavailable)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// a blocked retry returns the attempt before it
+	h = retryHandler(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	var discarded atomic.Int32
+	try, cancel = Retry(ctx, h, true, true, func(ctx context.Context, attempt int) Try[int] {
+		if attempt == 1 {
+			return Try[int]{Value: attempt, Err: errUnavailable, Retryable: true}
+		}
+		return Try[int]{Value: attempt, Err: ErrBlocked, Blocked: true}
+	}, func(int) { discarded.Add(1) })
+	cancel()
+	assert.ErrorIs(t, try.Err, errUnavailable)
+	assert.Equal(t, 1, try.Value)
+	assert.Equal(t, int32(1), discarded.Load())
+}
+
+func TestRetryHedge(t *testing.T) {
+	h := retryHandler(RetryPolicy{MaxAttempts: 2, HedgeAfter: 10 * time.Millisecond})
+	cancelled := make(chan int, 1)
+	try, cancel := Retry(context.Background(), h, true, true, func(ctx context.Context, attempt int) Try[int] {
+		if attempt == 1 {
+			<-ctx.Done() // slow, until the hedge wins
+			cancelled <- attempt
+			return Try[int]{Value: attempt, Err: ctx.Err()}
+		}
+		return Try[int]{Value: attempt}
+	}, nil)
+	cancel()
+	assert.NoError(t, try.Err)
+	assert.Equal(t, 2, try.Value)
+	assert.Equal(t, 1, <-cancelled)
+
+	// hedges come out of the retry budget too
+	assert.Equal(t, float64(RETRY_BUDGET_BURST-1), h.retryBudget.balance)
+}
@@ -9,3 +9,9 @@ func NewInboundHandler(gn string, fn *string, pb *int32, dw *float32, kv *map[st
 	h, err := NewHandler(gn, fn, pb, dw, kv)
 	return &InboundHandler{h}, err
 }
+
+// NewInboundHandlerWithOptions returns a new InboundHandler
+func NewInboundHandlerWithOptions(gn string, o Options) (*InboundHandler, error) {
+	h, err := NewHandlerWithOptions(gn, o)
+	return &InboundHandler{h}, err
+}
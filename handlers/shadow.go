@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/StanzaSystems/sdk-go/keys"
+	"github.com/StanzaSystems/sdk-go/limiter"
+
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
+	"github.com/alibaba/sentinel-golang/core/flow"
+	"github.com/alibaba/sentinel-golang/core/isolation"
+	"github.com/alibaba/sentinel-golang/core/stat"
+)
+
+// ContextWithShadow returns a context whose guards are evaluated in shadow
+// mode (see Options.Shadow), for dry-running a single call
+func ContextWithShadow(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keys.ShadowKey, true)
+}
+
+func isShadow(ctx context.Context) bool {
+	shadow, _ := ctx.Value(keys.ShadowKey).(bool)
+	return shadow
+}
+
+// Shadow guards never take a Sentinel entry or a concurrency slot (so they
+// can't use up capacity of enforced guards), whether they would be blocked is
+// estimated from the current state instead: flow (QPS) and isolation
+// (concurrency) rules against the resource's statistics, and circuit breakers
+// by whether they are open.
+
+var (
+	// open circuit breakers, keyed by resource and then rule
+	openBreakers     = map[string]map[string]*circuitbreaker.Rule{}
+	openBreakersLock = &sync.RWMutex{}
+)
+
+func init() {
+	circuitbreaker.RegisterStateChangeListeners(breakerListener{})
+}
+
+type breakerListener struct{}
+
+func (breakerListener) OnTransformToClosed(prev circuitbreaker.State, rule circuitbreaker.Rule) {
+	setBreakerOpen(&rule, false)
+}
+
+func (breakerListener) OnTransformToOpen(prev circuitbreaker.State, rule circuitbreaker.Rule, snapshot interface{}) {
+	setBreakerOpen(&rule, true)
+}
+
+func (breakerListener) OnTransformToHalfOpen(prev circuitbreaker.State, rule circuitbreaker.Rule) {
+	setBreakerOpen(&rule, false) // lets a probe through
+}
+
+func setBreakerOpen(rule *circuitbreaker.Rule, open bool) {
+	openBreakersLock.Lock()
+	defer openBreakersLock.Unlock()
+	key := rule.String()
+	if open {
+		if openBreakers[rule.Resource] == nil {
+			openBreakers[rule.Resource] = map[string]*circuitbreaker.Rule{}
+		}
+		openBreakers[rule.Resource][key] = rule
+	} else {
+		delete(openBreakers[rule.Resource], key)
+		if len(openBreakers[rule.Resource]) == 0 {
+			delete(openBreakers, rule.Resource)
+		}
+	}
+}
+
+// shadowLocal returns the Sentinel block a request for resource would likely
+// get (nil if it would likely pass), without entering the resource
+func shadowLocal(resource string) *base.BlockError {
+	var open *circuitbreaker.Rule
+	openBreakersLock.RLock()
+	for _, rule := range openBreakers[resource] {
+		open = rule
+		break
+	}
+	openBreakersLock.RUnlock()
+	if open != nil && len(circuitbreaker.GetRulesOfResource(resource)) > 0 {
+		return base.NewBlockErrorWithCause(base.BlockTypeCircuitBreaking, "circuit breaker check blocked", open, nil)
+	}
+
+	node := stat.GetResourceNode(resource)
+	if node == nil {
+		return nil // no traffic yet
+	}
+	for _, rule := range flow.GetRulesOfResource(resource) {
+		if rule.TokenCalculateStrategy != flow.Direct || rule.ControlBehavior != flow.Reject ||
+			rule.RelationStrategy != flow.CurrentResource {
+			continue // warm up, throttling, and related resources aren't estimated
+		}
+		if qps := node.GetQPS(base.MetricEventPass); qps+1 > rule.Threshold {
+			return base.NewBlockErrorWithCause(base.BlockTypeFlow, "flow reject check blocked", &rule, qps)
+		}
+	}
+	for _, rule := range isolation.GetRulesOfResource(resource) {
+		if n := node.CurrentConcurrency(); rule.MetricType == isolation.Concurrency && uint32(n)+1 > rule.Threshold {
+			return base.NewBlockErrorWithCause(base.BlockTypeIsolation, "concurrency exceeds threshold", &rule, n)
+		}
+	}
+	return nil
+}
+
+// shadowLimit returns the first limiter which would block a request (nil if
+// none would), without acquiring a slot
+func shadowLimit(ls []limiter.Limiter) limiter.Limiter {
+	for _, l := range ls {
+		if l.InFlight() >= l.Limit() {
+			return l
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StanzaSystems/sdk-go/keys"
+	"github.com/StanzaSystems/sdk-go/logging"
+
+	"go.opentelemetry.io/otel/baggage"
+	"google.golang.org/grpc/metadata"
+)
+
+// TagSource is what a TagExtractor can see of a request
+type TagSource interface {
+	Header(key string) string     // HTTP header or gRPC metadata value ("" if not found)
+	PathValue(name string) string // path parameter ("" if not found, or not HTTP)
+}
+
+const (
+	// Longest extracted tag value used, longer values are dropped
+	MAX_TAG_VALUE_LENGTH = 128
+
+	// Most distinct values a handler uses for each extracted tag (by default)
+	DEFAULT_MAX_TAG_VALUES = 1000
+
+	// Extracted values unused for this long make room for new ones (like
+	// cached leases, see hub.IDLE_LEASE_CACHE_TIMEOUT)
+	IDLE_TAG_VALUE_TIMEOUT = 5 * time.Minute
+
+	// How often a full extractor looks for idle values to evict
+	TAG_VALUE_SWEEP_INTERVAL = 1 * time.Second
+)
+
+// TagExtractor fills in a quota tag from each request, so per-customer (or
+// per-tenant, etc) quotas work without passing tags by hand. As with Tags,
+// only tags listed in the guard's config are used. Extracted values come from
+// the request (and so from the client), and every distinct value gets quota
+// leases of its own, so they are bounded: values longer than
+// MAX_TAG_VALUE_LENGTH, not in Allowed (if set), or beyond MaxValues distinct
+// values are dropped, leaving the request untagged. Values unused for
+// IDLE_TAG_VALUE_TIMEOUT make room for new ones.
+type TagExtractor struct {
+	Tag   string                                          // quota tag key
+	Value func(ctx context.Context, src TagSource) string // returns "" if not found
+
+	Allowed   []string // values which may be used (nil for any value)
+	MaxValues int      // most distinct values used per handler (0 for DEFAULT_MAX_TAG_VALUES)
+}
+
+// tagExtractor is a TagExtractor along with the values a handler has used
+type tagExtractor struct {
+	TagExtractor
+	lock   sync.Mutex
+	values map[string]time.Time // when each value was last used
+	swept  time.Time            // last looked for idle values
+	full   bool                 // logged that MaxValues was reached
+}
+
+func newTagExtractors(tes []TagExtractor) []*tagExtractor {
+	extractors := make([]*tagExtractor, 0, len(tes))
+	for _, te := range tes {
+		if te.MaxValues <= 0 {
+			te.MaxValues = DEFAULT_MAX_TAG_VALUES
+		}
+		extractors = append(extractors, &tagExtractor{TagExtractor: te, values: map[string]time.Time{}})
+	}
+	return extractors
+}
+
+// value returns the extracted value, or "" if there isn't one or it is dropped
+func (te *tagExtractor) value(ctx context.Context, src TagSource, guard string) string {
+	v := te.Value(ctx, src)
+	if v == "" || len(v) > MAX_TAG_VALUE_LENGTH {
+		return ""
+	}
+	if te.Allowed != nil {
+		if slices.Contains(te.Allowed, v) {
+			return v
+		}
+		return ""
+	}
+	now := time.Now()
+	te.lock.Lock()
+	defer te.lock.Unlock()
+	if _, ok := te.values[v]; ok {
+		te.values[v] = now
+		return v
+	}
+	if len(te.values) >= te.MaxValues && now.Sub(te.swept) >= TAG_VALUE_SWEEP_INTERVAL {
+		te.swept = now
+		for old, used := range te.values {
+			if now.Sub(used) > IDLE_TAG_VALUE_TIMEOUT {
+				delete(te.values, old)
+			}
+		}
+	}
+	if len(te.values) >= te.MaxValues {
+		if !te.full {
+			te.full = true
+			logging.Error(fmt.Errorf("too many values for quota tag %q, dropping new ones until some are idle", te.Tag),
+				"guard", guard, "max_values", te.MaxValues, "idle_timeout", IDLE_TAG_VALUE_TIMEOUT.String())
+		}
+		return ""
+	}
+	te.full = false
+	te.values[v] = now
+	return v
+}
+
+// TagFromHeader extracts a quota tag from an HTTP header or gRPC metadata key
+func TagFromHeader(tag, header string) TagExtractor {
+	return TagExtractor{Tag: tag, Value: func(ctx context.Context, src TagSource) string {
+		return src.Header(header)
+	}}
+}
+
+// TagFromPathValue extracts a quota tag from a path parameter (http.ServeMux
+// wildcards, or fiber route parameters)
+func TagFromPathValue(tag, name string) TagExtractor {
+	return TagExtractor{Tag: tag, Value: func(ctx context.Context, src TagSource) string {
+		return src.PathValue(name)
+	}}
+}
+
+// TagFromBaggage extracts a quota tag from an OTEL baggage member
+func TagFromBaggage(tag, member string) TagExtractor {
+	return TagExtractor{Tag: tag, Value: func(ctx context.Context, src TagSource) string {
+		return baggage.FromContext(ctx).Member(member).Value()
+	}}
+}
+
+// TagFromJWTClaim extracts a quota tag from a (top level) claim of the bearer
+// token in the Authorization header. The token's signature is NOT verified,
+// so clients can put anything in the claim: it is only used to pick a quota,
+// authenticate requests separately (and consider setting Allowed).
+func TagFromJWTClaim(tag, claim string) TagExtractor {
+	return TagExtractor{Tag: tag, Value: func(ctx context.Context, src TagSource) string {
+		auth := src.Header("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+			return ""
+		}
+		parts := strings.Split(strings.TrimSpace(auth[7:]), ".")
+		if len(parts) != 3 {
+			return ""
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err != nil {
+			return ""
+		}
+		claims := map[string]interface{}{}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return ""
+		}
+		switch v := claims[claim].(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		case float64, bool:
+			return fmt.Sprint(v)
+		default:
+			return ""
+		}
+	}}
+}
+
+// HTTPTagSource returns a TagSource for an HTTP request
+func HTTPTagSource(r *http.Request) TagSource {
+	return httpTagSource{r}
+}
+
+type httpTagSource struct {
+	r *http.Request
+}
+
+func (s httpTagSource) Header(key string) string {
+	return s.r.Header.Get(key)
+}
+
+func (s httpTagSource) PathValue(name string) string {
+	return s.r.PathValue(name)
+}
+
+// MetadataTagSource returns a TagSource for gRPC metadata
+func MetadataTagSource(md metadata.MD) TagSource {
+	return mdTagSource{md}
+}
+
+type mdTagSource struct {
+	md metadata.MD
+}
+
+func (s mdTagSource) Header(key string) string {
+	if v := s.md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (s mdTagSource) PathValue(name string) string {
+	return ""
+}
+
+// ExtractTags runs the handler's TagExtractors against src, returning a
+// context carrying the extracted quota tags. They are only used by guards of
+// this handler's guard, not by other guards called with the context.
+func (h *Handler) ExtractTags(ctx context.Context, src TagSource) context.Context {
+	if len(h.tagExtractors) == 0 {
+		return ctx
+	}
+	tags := map[string]string{}
+	for _, te := range h.tagExtractors {
+		if v := te.value(ctx, src, h.guardName); v != "" {
+			tags[te.Tag] = v
+		}
+	}
+	if len(tags) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, h.tagsKey, tags)
+}
+
+// ContextWithTags returns a context carrying quota tags for the guards of a
+// single call (they are merged with, and override, the handler's Tags)
+func ContextWithTags(ctx context.Context, tags map[string]string) context.Context {
+	return context.WithValue(ctx, keys.TagsKey, tags)
+}
+
+func contextTags(ctx context.Context, key keys.ContextKey) map[string]string {
+	tags, _ := ctx.Value(key).(map[string]string)
+	return tags
+}
+
+// tagsFor returns the handler's Tags merged with any from ctx (from
+// ContextWithTags, then those extracted for this guard)
+func (h *Handler) tagsFor(ctx context.Context) *map[string]string {
+	ctxTags := contextTags(ctx, keys.TagsKey)
+	extracted := contextTags(ctx, h.tagsKey)
+	if len(ctxTags) == 0 && len(extracted) == 0 {
+		return h.tags
+	}
+	tags := map[string]string{}
+	if h.tags != nil {
+		for k, v := range *h.tags {
+			tags[k] = v
+		}
+	}
+	for k, v := range ctxTags {
+		tags[k] = v
+	}
+	for k, v := range extracted {
+		tags[k] = v
+	}
+	return &tags
+}
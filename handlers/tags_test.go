@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTagExtractors(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-1","org_id":42}`))
+	r := httptest.NewRequest("GET", "/customers/acme", nil)
+	r.Header.Set("X-Customer-Id", "acme")
+	r.Header.Set("Authorization", "Bearer header."+claims+".signature")
+	r.SetPathValue("customer", "acme")
+
+	h, _ := NewHandlerWithOptions("tags-guard", Options{TagExtractors: []TagExtractor{
+		TagFromHeader("customer_header", "X-Customer-Id"),
+		TagFromJWTClaim("org", "org_id"),
+		TagFromJWTClaim("missing", "not_a_claim"),
+		TagFromPathValue("customer_path", "customer"),
+	}})
+	ctx := h.ExtractTags(context.Background(), HTTPTagSource(r))
+	assert.Equal(t, map[string]string{
+		"customer_header": "acme",
+		"org":             "42",
+		"customer_path":   "acme",
+	}, contextTags(ctx, h.tagsKey))
+
+	// only for guards of the same guard, not every guard called with ctx
+	other, _ := NewHandlerWithOptions("other-guard", Options{})
+	assert.Nil(t, other.tagsFor(ctx))
+
+	// handler tags are merged, request tags win
+	h.tags = &map[string]string{"org": "default", "tier": "free"}
+	assert.Equal(t, &map[string]string{
+		"customer_header": "acme",
+		"org":             "42",
+		"customer_path":   "acme",
+		"tier":            "free",
+	}, h.tagsFor(ctx))
+}
+
+func TestMetadataTagSource(t *testing.T) {
+	src := MetadataTagSource(metadata.Pairs("x-customer-id", "acme"))
+	assert.Equal(t, "acme", src.Header("X-Customer-Id"))
+	assert.Equal(t, "", src.PathValue("customer"))
+}
+
+func TestTagExtractorBounds(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	extract := func(h *Handler, customer string) string {
+		r.Header.Set("X-Customer-Id", customer)
+		return contextTags(h.ExtractTags(context.Background(), HTTPTagSource(r)), h.tagsKey)["customer"]
+	}
+
+	te := TagFromHeader("customer", "X-Customer-Id")
+	te.MaxValues = 2
+	h, _ := NewHandlerWithOptions("bounded-tags-guard", Options{TagExtractors: []TagExtractor{te}})
+	assert.Equal(t, "a", extract(h, "a"))
+	assert.Equal(t, "b", extract(h, "b"))
+	assert.Equal(t, "", extract(h, "c"), "over MaxValues")
+	assert.Equal(t, "a", extract(h, "a"), "values already used still are")
+	assert.Equal(t, "", extract(h, strings.Repeat("x", MAX_TAG_VALUE_LENGTH+1)), "too long")
+
+	// once a value is idle, it makes room for a new one
+	extractor := h.tagExtractors[0]
+	extractor.lock.Lock()
+	extractor.values["b"] = time.Now().Add(-IDLE_TAG_VALUE_TIMEOUT - time.Second)
+	extractor.swept = time.Time{}
+	extractor.lock.Unlock()
+	assert.Equal(t, "c", extract(h, "c"))
+	assert.Equal(t, "a", extract(h, "a"))
+	assert.Equal(t, "", extract(h, "b"), "evicted, and nothing is idle")
+
+	te.Allowed = []string{"acme"}
+	h, _ = NewHandlerWithOptions("allowed-tags-guard", Options{TagExtractors: []TagExtractor{te}})
+	assert.Equal(t, "acme", extract(h, "acme"))
+	assert.Equal(t, "", extract(h, "other"), "not allowed")
+}
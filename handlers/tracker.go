@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/StanzaSystems/sdk-go/global"
+	"github.com/StanzaSystems/sdk-go/logging"
+)
+
+type trackedGuard struct {
+	created  time.Time
+	stack    []byte
+	reported bool
+}
+
+var (
+	// outstanding (allowed but not yet ended) guards, only when tracking is enabled
+	trackedGuards     = map[*Guard]*trackedGuard{}
+	trackedGuardsLock = &sync.Mutex{}
+	trackThreshold    atomic.Int64
+	trackerOnce       sync.Once
+)
+
+// TrackGuards is a debugging aid which records the creation stack trace of
+// every allowed guard and reports (once) any guard which hasn't been ended
+// within threshold. It is expensive, don't leave it enabled in production.
+// A threshold of zero disables tracking.
+func TrackGuards(threshold time.Duration) {
+	trackThreshold.Store(int64(threshold))
+	if threshold <= 0 {
+		trackedGuardsLock.Lock()
+		clear(trackedGuards)
+		trackedGuardsLock.Unlock()
+		return
+	}
+	trackerOnce.Do(func() { go guardTracker() })
+}
+
+func track(g *Guard) {
+	if trackThreshold.Load() <= 0 {
+		return
+	}
+	trackedGuardsLock.Lock()
+	defer trackedGuardsLock.Unlock()
+	trackedGuards[g] = &trackedGuard{created: time.Now(), stack: debug.Stack()}
+}
+
+func untrack(g *Guard) {
+	if trackThreshold.Load() <= 0 {
+		return
+	}
+	trackedGuardsLock.Lock()
+	defer trackedGuardsLock.Unlock()
+	delete(trackedGuards, g)
+}
+
+func guardTracker() {
+	for {
+		threshold := time.Duration(trackThreshold.Load())
+		interval := threshold / 2
+		if interval < time.Second {
+			interval = time.Second
+		}
+		select {
+		case <-global.Done():
+			return
+		case <-time.After(interval):
+			if threshold <= 0 {
+				continue
+			}
+			reportTrackedGuards(threshold)
+		}
+	}
+}
+
+// reportTrackedGuards reports (once) every tracked guard which hasn't been
+// ended within threshold, returning how many were reported
+func reportTrackedGuards(threshold time.Duration) int {
+	trackedGuardsLock.Lock()
+	defer trackedGuardsLock.Unlock()
+	reported := 0
+	for g, tg := range trackedGuards {
+		if !tg.reported && time.Since(tg.created) > threshold {
+			tg.reported = true
+			reported += 1
+			logging.Warn("guard not ended",
+				append(g.logAttr(nil),
+					"age", time.Since(tg.created).String(),
+					"stack", string(tg.stack))...)
+		}
+	}
+	return reported
+}
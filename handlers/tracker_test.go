@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackGuards(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetGuardConfig("tracked-guard", &hubv1.GuardConfig{})
+	h, _ := NewHandlerWithOptions("tracked-guard", Options{})
+
+	// the tracker itself only sweeps every threshold/2, so sweep directly
+	TrackGuards(time.Hour)
+	t.Cleanup(func() { TrackGuards(0) })
+	threshold := 50 * time.Millisecond
+
+	held := h.Guard(context.Background(), nil, nil)
+	ended := h.Guard(context.Background(), nil, nil)
+	assert.Equal(t, 0, reportTrackedGuards(threshold))
+	ended.End(ended.Success)
+
+	// only the guard held past the threshold is reported, and only once
+	time.Sleep(2 * threshold)
+	assert.Equal(t, 1, reportTrackedGuards(threshold))
+	assert.Equal(t, 0, reportTrackedGuards(threshold))
+	trackedGuardsLock.Lock()
+	assert.Contains(t, trackedGuards, held)
+	assert.NotContains(t, trackedGuards, ended)
+	trackedGuardsLock.Unlock()
+
+	held.End(held.Success)
+	trackedGuardsLock.Lock()
+	assert.Empty(t, trackedGuards)
+	trackedGuardsLock.Unlock()
+}
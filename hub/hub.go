@@ -3,12 +3,10 @@ package hub
 import (
 	"context"
 	"errors"
-	"os"
-	"os/signal"
+	"fmt"
 	"slices"
 	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
@@ -16,7 +14,8 @@ import (
 	"github.com/StanzaSystems/sdk-go/logging"
 	"github.com/StanzaSystems/sdk-go/otel"
 
-	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -25,24 +24,41 @@ const (
 	MAX_QUOTA_WAIT               = 1 * time.Second
 	CACHED_LEASE_CHECK_INTERVAL  = 200 * time.Millisecond // TODO: what should this be set to?
 	BATCH_TOKEN_CONSUME_INTERVAL = 200 * time.Millisecond // TODO: what should this be set to?
+	DEFAULT_RETRY_AFTER          = 1 * time.Second        // suggested retry delay when we have no lease data
+	UNKNOWN_TAG_LOG_INTERVAL     = 1 * time.Minute        // log each unknown tag (per guard) at most this often
+	IDLE_LEASE_CACHE_TIMEOUT     = 5 * time.Minute        // evict cached leases of quota tag sets unused for this long
+	MAX_WEIGHT_CORRECTIONS       = 10000                  // most weight corrections waiting to be sent, more are dropped
+	MAX_WEIGHT_CORRECTION_TRIES  = 5                      // weight corrections which failed this many times are dropped
+	MAX_TAGGED_LEASE_CACHES      = 1000                   // most quota tag sets with cached leases, the least recently used is evicted past this
 )
 
 var (
-	waitingLeasesMapLock = &sync.RWMutex{}
-	waitingLeases        = make(map[string][]*hubv1.TokenLease)
-	waitingLeasesLock    = make(map[string]*sync.RWMutex)
-
-	cachedLeasesMapLock = &sync.RWMutex{}
-	cachedLeases        = make(map[string][]*hubv1.TokenLease)
-	cachedLeasesLock    = make(map[string]*sync.RWMutex)
-	cachedLeasesUsed    = make(map[string]int)
-	cachedLeasesReq     = make(map[string]*hubv1.GetTokenLeaseRequest)
-	cachedLeasesInit    sync.Once
+	// cached leases, keyed by guard and quota tag set (see leaseKey)
+	leaseCaches       = make(map[string]*leaseCache)
+	leaseCachesLock   = &sync.RWMutex{}
+	taggedLeaseCaches = 0 // how many of leaseCaches are tagged
+	cachedLeasesInit  sync.Once
 
 	consumedLeases     = []string{}
 	consumedLeasesLock = &sync.RWMutex{}
 	consumedLeasesInit sync.Once
 
+	// actual weights of requests which consumed leases, keyed by lease token
+	// (sent along with consumed leases until acknowledged, see CorrectWeight)
+	weightCorrections = make(map[string]*weightCorrection)
+
+	// most recently granted lease duration, per guard
+	leaseDurations     = make(map[string]time.Duration)
+	leaseDurationsLock = &sync.RWMutex{}
+
+	// when quota was last blocked, per guard and feature (for SimulateQuota)
+	quotaBlocked     = make(map[string]time.Time)
+	quotaBlockedLock = &sync.RWMutex{}
+
+	// when each unknown tag was last logged, and how often it was skipped since
+	unknownTags     = make(map[string]*unknownTag)
+	unknownTagsLock = &sync.Mutex{}
+
 	failOpenCount = int64(0)
 )
 
@@ -77,13 +93,15 @@ func NewTokenLeaseRequest(ctx context.Context, gn string, fn *string, pb *int32,
 		if len(*tags) > 0 {
 			guardConfig, _, err := global.GetGuardConfig(ctx, gn)
 			if err != nil {
-				logging.Error(err)
+				if !errors.Is(err, global.ErrNotInitialized) {
+					logging.Error(err) // before Init guards fail open quietly
+				}
 			} else {
 				for k, v := range *tags {
 					if slices.Contains(guardConfig.QuotaTags, k) {
 						tlr.Selector.Tags = append(tlr.Selector.Tags, &hubv1.Tag{Key: k, Value: v})
 					} else {
-						logging.Info("skipping unknown tag", "tag", k, "guard", gn)
+						logUnknownTag(gn, k)
 					}
 				}
 			}
@@ -92,7 +110,33 @@ func NewTokenLeaseRequest(ctx context.Context, gn string, fn *string, pb *int32,
 	return ctx, &tlr
 }
 
-func CheckQuota(_ context.Context, tlr *hubv1.GetTokenLeaseRequest) (hubv1.Quota, string, error) {
+type unknownTag struct {
+	logged  time.Time
+	skipped int
+}
+
+// logUnknownTag logs tags which aren't in the guard's config, at most once per
+// UNKNOWN_TAG_LOG_INTERVAL (per guard and tag)
+func logUnknownTag(guard, tag string) {
+	key := guard + "\x00" + tag
+	unknownTagsLock.Lock()
+	ut, ok := unknownTags[key]
+	if !ok {
+		ut = &unknownTag{}
+		unknownTags[key] = ut
+	}
+	ut.skipped += 1
+	if time.Since(ut.logged) < UNKNOWN_TAG_LOG_INTERVAL {
+		unknownTagsLock.Unlock()
+		return
+	}
+	skipped := ut.skipped
+	ut.logged, ut.skipped = time.Now(), 0
+	unknownTagsLock.Unlock()
+	logging.Info("skipping unknown tag", "tag", tag, "guard", guard, "count", skipped)
+}
+
+func CheckQuota(ctx context.Context, tlr *hubv1.GetTokenLeaseRequest) (hubv1.Quota, string, error) {
 	if tlr == nil || tlr.Selector == nil {
 		errMsg := "invalid token lease request, failing open"
 		logging.Debug(errMsg, "count", atomic.AddInt64(&failOpenCount, 1))
@@ -107,64 +151,21 @@ func CheckQuota(_ context.Context, tlr *hubv1.GetTokenLeaseRequest) (hubv1.Quota
 	guard := tlr.GetSelector().GetGuardName()
 
 	// start a background batch token consumer
-	consumedLeasesInit.Do(func() { go batchTokenConsumer() })
-
-	cachedLeasesMapLock.RLock()
-	_, cachedLeasesExists := cachedLeases[guard]
-	cachedLeasesMapLock.RUnlock()
-	if !cachedLeasesExists {
-		cachedLeasesMapLock.Lock()
-		cachedLeases[guard] = []*hubv1.TokenLease{}
-		cachedLeasesLock[guard] = &sync.RWMutex{}
-		cachedLeasesUsed[guard] = 0
-		cachedLeasesReq[guard] = &hubv1.GetTokenLeaseRequest{
-			Selector: &hubv1.GuardFeatureSelector{
-				Environment: tlr.GetSelector().GetEnvironment(),
-				GuardName:   tlr.GetSelector().GetGuardName(),
-			},
-			ClientId: tlr.ClientId,
-		}
-		cachedLeasesMapLock.Unlock()
-	}
-	waitingLeasesMapLock.RLock()
-	_, waitingLeasesExists := waitingLeases[guard]
-	waitingLeasesMapLock.RUnlock()
-	if !waitingLeasesExists {
-		waitingLeasesMapLock.Lock()
-		waitingLeases[guard] = []*hubv1.TokenLease{}
-		waitingLeasesLock[guard] = &sync.RWMutex{}
-		waitingLeasesMapLock.Unlock()
-
-	}
-
-	if len(tlr.GetSelector().GetTags()) == 0 { // fully skip using cached leases if Quota Tags are specified
-		cachedLeasesLock[guard].RLock()
-		cachedLeaseLen := len(cachedLeases[guard])
-		cachedLeasesLock[guard].RUnlock()
-		if cachedLeaseLen > 0 {
-			cachedLeasesLock[guard].Lock()
-			for k, tl := range cachedLeases[guard] {
-				if tl.GetFeature() == tlr.GetSelector().GetFeatureName() {
-					if tl.GetPriorityBoost() <= tlr.GetPriorityBoost() {
-						if time.Now().Before(tl.GetExpiresAt().AsTime()) {
-							// We have a cached lease for the given feature, at the right priority,
-							// which hasn't expired; remove from cache, unlock, and return cached token
-							newCache := append(cachedLeases[guard][:k], cachedLeases[guard][k+1:]...)
-							cachedLeases[guard] = newCache
-							cachedLeasesUsed[guard] += 1
-							cachedLeasesLock[guard].Unlock()
-							return hubv1.Quota_QUOTA_GRANTED, tl.Token, nil
-						}
-					}
-				}
-			}
-			// No cached lease available for Feature+PriorityBoost;
-			// unlock and proceed to make a GetTokenLease request below
-			cachedLeasesLock[guard].Unlock()
-		}
+	consumedLeasesInit.Do(func() {
+		global.OnShutdown(flushConsumedLeases)
+		go batchTokenConsumer()
+	})
+
+	lc := getLeaseCache(tlr)
+	lc.lastUsed.Store(time.Now().UnixNano())
+	if token, ok := lc.take(tlr.GetSelector().GetFeatureName(), tlr.GetPriorityBoost()); ok {
+		return hubv1.Quota_QUOTA_GRANTED, token, nil
 	}
+	// No cached lease available for Feature+PriorityBoost;
+	// proceed to make a GetTokenLease request below
 
-	ctx, cancel := context.WithTimeout(context.Background(), MAX_QUOTA_WAIT)
+	// wait for up to MAX_QUOTA_WAIT (or less, if ctx has an earlier deadline)
+	ctx, cancel := context.WithTimeout(ctx, MAX_QUOTA_WAIT)
 	defer cancel()
 
 	for {
@@ -172,7 +173,7 @@ func CheckQuota(_ context.Context, tlr *hubv1.GetTokenLeaseRequest) (hubv1.Quota
 		case <-ctx.Done():
 			return hubv1.Quota_QUOTA_TIMEOUT, "", ctx.Err() // deadline reached, log error and fail open
 		default:
-			resp, err := qsc.GetTokenLease(metadata.NewOutgoingContext(ctx, global.XStanzaKey()), tlr)
+			resp, err := qsc.GetTokenLease(ctx, tlr)
 			if err != nil {
 				// TODO: Implement Error Handling as specified in SDK spec:
 				// If quota is required and the Stanza hub is unresponsive or does not return a valid
@@ -190,8 +191,16 @@ func CheckQuota(_ context.Context, tlr *hubv1.GetTokenLeaseRequest) (hubv1.Quota
 			}
 			leases := resp.GetLeases()
 			if len(leases) == 0 {
+				quotaBlockedLock.Lock()
+				quotaBlocked[quotaKey(tlr)] = time.Now()
+				quotaBlockedLock.Unlock()
 				return hubv1.Quota_QUOTA_BLOCKED, "", nil // not an error, there were no leases available
 			}
+			if d := leases[0].GetDurationMsec(); d > 0 {
+				leaseDurationsLock.Lock()
+				leaseDurations[guard] = time.Duration(d) * time.Millisecond
+				leaseDurationsLock.Unlock()
+			}
 			if len(leases[1:]) > 0 {
 				// Start a background cached lease manager (the first time we get extra leases from Stanza Hub)
 				cachedLeasesInit.Do(func() { go cachedLeaseManager() })
@@ -202,11 +211,7 @@ func CheckQuota(_ context.Context, tlr *hubv1.GetTokenLeaseRequest) (hubv1.Quota
 						lease.ExpiresAt = timestamppb.New(time.Now().Add(time.Duration(lease.DurationMsec) * time.Millisecond))
 					}
 				}
-				// use a separate "waiting leases" lock here as we don't need/want to block a request on contention for
-				// the higher volume / harder to get "cached leases" lock
-				waitingLeasesLock[guard].Lock()
-				waitingLeases[guard] = append(waitingLeases[guard], leases[1:]...)
-				waitingLeasesLock[guard].Unlock()
+				lc.addWaiting(leases[1:])
 			}
 
 			// Consume first token from leases (not cached, so this doesn't require the cached leases lock)
@@ -216,6 +221,45 @@ func CheckQuota(_ context.Context, tlr *hubv1.GetTokenLeaseRequest) (hubv1.Quota
 	}
 }
 
+// SimulateQuota reports what CheckQuota would decide, without consuming any
+// quota. Stanza Hub has no dry-run lease request, so this is a local estimate
+// from what the SDK already knows about the request's guard, feature, and quota
+// tags: blocked if their quota was blocked within the last lease duration (see
+// RetryAfter), granted if there is a cached lease CheckQuota could use, and
+// QUOTA_UNSPECIFIED (unknown) otherwise, like for a guard which never enforced.
+func SimulateQuota(tlr *hubv1.GetTokenLeaseRequest) (hubv1.Quota, error) {
+	if tlr == nil || tlr.Selector == nil {
+		return hubv1.Quota_QUOTA_NOT_EVAL, errors.New("invalid token lease request, failing open")
+	}
+	quotaBlockedLock.RLock()
+	blockedAt, ok := quotaBlocked[quotaKey(tlr)]
+	quotaBlockedLock.RUnlock()
+	if ok && time.Since(blockedAt) < RetryAfter(tlr.GetSelector().GetGuardName()) {
+		return hubv1.Quota_QUOTA_BLOCKED, nil
+	}
+	if lc := findLeaseCache(tlr); lc != nil && lc.has(tlr.GetSelector().GetFeatureName(), tlr.GetPriorityBoost()) {
+		return hubv1.Quota_QUOTA_GRANTED, nil
+	}
+	return hubv1.Quota_QUOTA_UNSPECIFIED, nil
+}
+
+// quotaKey identifies a request's quota, its lease cache plus its feature
+func quotaKey(tlr *hubv1.GetTokenLeaseRequest) string {
+	return leaseKey(tlr) + "\x00\x00" + tlr.GetSelector().GetFeatureName()
+}
+
+// RetryAfter suggests how long to wait before retrying a request which was
+// blocked for lack of quota. Quota is granted in leases, so new quota should be
+// available about one lease duration later.
+func RetryAfter(guard string) time.Duration {
+	leaseDurationsLock.RLock()
+	defer leaseDurationsLock.RUnlock()
+	if d, ok := leaseDurations[guard]; ok {
+		return d
+	}
+	return DEFAULT_RETRY_AFTER
+}
+
 func consumeLease(guard string, lease *hubv1.TokenLease) {
 	consumedLeasesLock.Lock()
 	consumedLeases = append(consumedLeases, lease.GetToken())
@@ -228,124 +272,210 @@ func consumeLease(guard string, lease *hubv1.TokenLease) {
 	// 	"priority_boost", lease.PriorityBoost)
 }
 
+// CorrectWeight reports the actual weight (cost) of the request which consumed
+// the lease token, for requests whose weight is only known after they ran
+func CorrectWeight(token string, weight float32) {
+	if token == "" {
+		return
+	}
+	consumedLeasesLock.Lock()
+	defer consumedLeasesLock.Unlock()
+	if _, ok := weightCorrections[token]; !ok && len(weightCorrections) >= MAX_WEIGHT_CORRECTIONS {
+		logging.Debug("dropped weight correction, too many are pending", "pending", len(weightCorrections))
+		return
+	}
+	weightCorrections[token] = &weightCorrection{weight: weight}
+}
+
 func batchTokenConsumer() {
-	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	for {
 		select {
-		case <-ctx.Done():
-			if global.QuotaServiceClient() != nil {
-				// (attempt to) flush consumed token leases to hub when we exit
-				ctx, cancel := context.WithTimeout(context.Background(), MAX_QUOTA_WAIT)
-				defer cancel()
-				global.QuotaServiceClient().SetTokenLeaseConsumed(
-					metadata.NewOutgoingContext(ctx, global.XStanzaKey()),
-					&hubv1.SetTokenLeaseConsumedRequest{
-						Tokens:      consumedLeases,
-						Environment: global.GetServiceEnvironment(),
-					})
-			}
+		case <-global.Done():
 			return
 		case <-time.After(BATCH_TOKEN_CONSUME_INTERVAL):
 			if global.QuotaServiceClient() != nil {
-				consumedLeasesLock.Lock()
-				if len(consumedLeases) == 0 {
-					consumedLeasesLock.Unlock()
-				} else {
-					consumeTokenReq := &hubv1.SetTokenLeaseConsumedRequest{
-						Tokens:      consumedLeases,
-						Environment: global.GetServiceEnvironment(),
-					}
-					consumedLeases = []string{}
-					consumedLeasesLock.Unlock()
-
-					ctx, cancel := context.WithTimeout(context.Background(), MAX_QUOTA_WAIT)
-					defer cancel()
-					_, err := global.QuotaServiceClient().SetTokenLeaseConsumed(
-						metadata.NewOutgoingContext(ctx, global.XStanzaKey()),
-						consumeTokenReq)
-					if err != nil {
-						// if our request failed, put leases back (so they will be attempted again later)
-						consumedLeasesLock.Lock()
-						consumedLeases = append(consumedLeases, consumeTokenReq.Tokens...)
-						consumedLeasesLock.Unlock()
-						logging.Error(err)
-						// TODO: add an exponential backoff sleep here?
-					}
+				ctx, cancel := context.WithTimeout(context.Background(), MAX_QUOTA_WAIT)
+				if err := setTokenLeasesConsumed(ctx); err != nil {
+					logging.Error(err)
+					// TODO: add an exponential backoff sleep here?
 				}
+				cancel()
 			}
 		}
 	}
 }
 
+// (attempt to) flush consumed token leases to hub when we exit
+func flushConsumedLeases(ctx context.Context) {
+	if global.QuotaServiceClient() != nil {
+		if err := setTokenLeasesConsumed(ctx); err != nil {
+			logging.Error(err)
+		}
+	}
+}
+
+// weightCorrection is a pending weight correction, and how often sending it failed
+type weightCorrection struct {
+	weight float32
+	tries  int
+}
+
+// setTokenLeasesConsumed sends the consumed leases along with any weight
+// corrections, batched as one request per distinct weight (a correction applies
+// to every token of the request carrying it)
+func setTokenLeasesConsumed(ctx context.Context) error {
+	qsc := global.QuotaServiceClient()
+	if qsc == nil {
+		return nil // kept until we are (re)connected
+	}
+	consumedLeasesLock.Lock()
+	if len(consumedLeases) == 0 && len(weightCorrections) == 0 {
+		consumedLeasesLock.Unlock()
+		return nil
+	}
+	tokens := consumedLeases
+	consumedLeases = []string{}
+	corrections := make(map[string]float32, len(weightCorrections))
+	for token, wc := range weightCorrections {
+		corrections[token] = wc.weight
+	}
+	consumedLeasesLock.Unlock()
+
+	var errs []error
+	for _, req := range consumedRequests(tokens, corrections) {
+		_, err := qsc.SetTokenLeaseConsumed(ctx, req)
+		if err = consumedResult(req, err); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// consumedResult updates the pending leases and weight corrections with the
+// result of a request: failed leases are put back (so they will be attempted
+// again later), corrections stay until they are acknowledged. Neither is kept
+// if the hub rejected the request for good, and corrections are dropped after
+// MAX_WEIGHT_CORRECTION_TRIES failures.
+func consumedResult(req *hubv1.SetTokenLeaseConsumedRequest, err error) error {
+	consumedLeasesLock.Lock()
+	defer consumedLeasesLock.Unlock()
+	permanent := err != nil && rejected(err)
+	if req.WeightCorrection == nil {
+		if permanent {
+			return fmt.Errorf("dropped %d consumed leases: %w", len(req.Tokens), err)
+		} else if err != nil {
+			consumedLeases = append(consumedLeases, req.Tokens...)
+		}
+	} else {
+		dropped := 0
+		for _, token := range req.Tokens {
+			wc, ok := weightCorrections[token]
+			if !ok || wc.weight != *req.WeightCorrection {
+				continue // corrected again since
+			}
+			if err != nil && !permanent {
+				if wc.tries += 1; wc.tries < MAX_WEIGHT_CORRECTION_TRIES {
+					continue
+				}
+			}
+			if err != nil {
+				dropped += 1
+			}
+			delete(weightCorrections, token)
+		}
+		if dropped > 0 {
+			return fmt.Errorf("dropped %d weight corrections: %w", dropped, err)
+		}
+	}
+	return err
+}
+
+// rejected returns true for hub errors which retrying won't fix
+func rejected(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition,
+		codes.Unauthenticated, codes.PermissionDenied, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+// consumedRequests batches consumed tokens without a weight correction into
+// one request, followed by one request per distinct corrected weight (which
+// also consumes any of its tokens not consumed yet, so no token is sent twice)
+func consumedRequests(tokens []string, corrections map[string]float32) []*hubv1.SetTokenLeaseConsumedRequest {
+	var reqs []*hubv1.SetTokenLeaseConsumedRequest
+	plain := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if _, ok := corrections[token]; !ok {
+			plain = append(plain, token)
+		}
+	}
+	if len(plain) > 0 {
+		reqs = append(reqs, &hubv1.SetTokenLeaseConsumedRequest{
+			Tokens:      plain,
+			Environment: global.GetServiceEnvironment(),
+		})
+	}
+	byWeight := make(map[float32][]string)
+	weights := []float32{}
+	for token, weight := range corrections {
+		if _, ok := byWeight[weight]; !ok {
+			weights = append(weights, weight)
+		}
+		byWeight[weight] = append(byWeight[weight], token)
+	}
+	slices.Sort(weights)
+	for _, weight := range weights {
+		reqs = append(reqs, &hubv1.SetTokenLeaseConsumedRequest{
+			Tokens:           byWeight[weight],
+			WeightCorrection: proto.Float32(weight),
+			Environment:      global.GetServiceEnvironment(),
+		})
+	}
+	return reqs
+}
+
 func cachedLeaseManager() {
-	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	for {
 		select {
-		case <-ctx.Done():
+		case <-global.Done():
 			return
 		case <-time.After(CACHED_LEASE_CHECK_INTERVAL):
-			for guard := range cachedLeases {
-				newCache := []*hubv1.TokenLease{}
-				expiringLeaseCount := 0
-				cachedLeasesLock[guard].Lock()
-				cachedLeaseCount := len(cachedLeases[guard])
-
-				// Check for and remove any expired leases
-				for k, tl := range cachedLeases[guard] {
-					if time.Now().Before(tl.GetExpiresAt().AsTime()) {
-						newCache = append(newCache, cachedLeases[guard][k])
-					} else {
-						cachedLeasesUsed[guard] += 1
-					}
-				}
-
-				// Check for number of leases within 2 seconds of expiring
-				for _, tl := range newCache {
-					if time.Now().Before(tl.GetExpiresAt().AsTime().Add(-2 * time.Second)) {
-						expiringLeaseCount += 1
-					}
-				}
-
-				// Add any additional leases waiting to be cached now
-				waitingLeasesLock[guard].Lock()
-				if len(waitingLeases[guard]) > 0 {
-					newCache = append(newCache, waitingLeases[guard]...)
-					cachedLeaseCount += len(waitingLeases[guard])
-					cachedLeasesUsed[guard] = 0
-					waitingLeases[guard] = []*hubv1.TokenLease{}
+			leaseCachesLock.RLock()
+			caches := make(map[string]*leaseCache, len(leaseCaches))
+			for key, lc := range leaseCaches {
+				caches[key] = lc
+			}
+			leaseCachesLock.RUnlock()
+
+			for key, lc := range caches {
+				if lc.idle() {
+					leaseCachesLock.Lock()
+					evictLeaseCache(key)
+					leaseCachesLock.Unlock()
+					logging.Debug("evicted idle cached leases", "guard", lc.req.GetSelector().GetGuardName())
+					continue
 				}
-				waitingLeasesLock[guard].Unlock()
-
-				// Make a GetTokenLease request if >80% of our tokens are already used (or expiring soon)
-				if global.QuotaServiceClient() != nil {
-					if float32((cachedLeaseCount-expiringLeaseCount)/(cachedLeaseCount+cachedLeasesUsed[guard])) < 0.2 {
-						go func() {
-							ctx, cancel := context.WithTimeout(context.Background(), CACHED_LEASE_CHECK_INTERVAL)
-							defer cancel()
-							resp, err := global.QuotaServiceClient().GetTokenLease(
-								metadata.NewOutgoingContext(ctx, global.XStanzaKey()),
-								cachedLeasesReq[guard])
-							if err != nil {
-								logging.Error(err)
-							}
-							if len(resp.GetLeases()) > 0 {
-								waitingLeasesLock[guard].Lock()
-								waitingLeases[guard] = append(waitingLeases[guard], resp.GetLeases()...)
-								waitingLeasesLock[guard].Unlock()
-							}
-						}()
-					}
+				if qsc := global.QuotaServiceClient(); lc.refresh() && qsc != nil {
+					go func() {
+						ctx, cancel := context.WithTimeout(context.Background(), CACHED_LEASE_CHECK_INTERVAL)
+						defer cancel()
+						resp, err := qsc.GetTokenLease(ctx, lc.req)
+						if err != nil {
+							logging.Error(err)
+						}
+						if len(resp.GetLeases()) > 0 {
+							lc.addWaiting(resp.GetLeases())
+						}
+					}()
 				}
-
-				// Update the cached leases store
-				cachedLeases[guard] = newCache
-				cachedLeasesLock[guard].Unlock()
 			}
 		}
 	}
 }
 
-func ValidateTokens(_ context.Context, guard string, tokens []string) (hubv1.Token, error) {
+func ValidateTokens(ctx context.Context, guard string, tokens []string) (hubv1.Token, error) {
 	qsc := global.QuotaServiceClient()
 	if qsc == nil {
 		errMsg := "invalid quota service client, failing open"
@@ -364,7 +494,8 @@ func ValidateTokens(_ context.Context, guard string, tokens []string) (hubv1.Tok
 	gs := &hubv1.GuardSelector{Environment: global.GetServiceEnvironment(), Name: guard}
 	vtr := &hubv1.ValidateTokenRequest{Tokens: tokenInfos(tokens, gs)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), MAX_QUOTA_WAIT)
+	// wait for up to MAX_QUOTA_WAIT (or less, if ctx has an earlier deadline)
+	ctx, cancel := context.WithTimeout(ctx, MAX_QUOTA_WAIT)
 	defer cancel()
 
 	for {
@@ -372,7 +503,7 @@ func ValidateTokens(_ context.Context, guard string, tokens []string) (hubv1.Tok
 		case <-ctx.Done():
 			return hubv1.Token_TOKEN_VALIDATION_TIMEOUT, ctx.Err() // deadline reached, log error and fail open
 		default:
-			resp, err := qsc.ValidateToken(metadata.NewOutgoingContext(ctx, global.XStanzaKey()), vtr)
+			resp, err := qsc.ValidateToken(ctx, vtr)
 			if err != nil {
 				return hubv1.Token_TOKEN_VALIDATION_ERROR, err // error from Stanza Hub, log error and fail open
 			}
@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestSimulateQuota(t *testing.T) {
+	tlr := tagged("customer", "simulated")
+	tlr.Selector.FeatureName = proto.String("feat")
+
+	quota, err := SimulateQuota(tlr)
+	assert.NoError(t, err)
+	assert.Equal(t, hubv1.Quota_QUOTA_UNSPECIFIED, quota, "unknown without anything to go on")
+
+	lc := getLeaseCache(tlr)
+	lc.addWaiting([]*hubv1.TokenLease{
+		{Token: "t1", Feature: "feat", ExpiresAt: timestamppb.New(time.Now().Add(time.Minute))},
+	})
+	lc.refresh()
+	quota, _ = SimulateQuota(tlr)
+	assert.Equal(t, hubv1.Quota_QUOTA_GRANTED, quota, "granted with a cached lease")
+	_, ok := lc.take("feat", 0)
+	assert.True(t, ok, "the lease isn't consumed")
+
+	quotaBlockedLock.Lock()
+	quotaBlocked[quotaKey(tlr)] = time.Now()
+	quotaBlockedLock.Unlock()
+	quota, _ = SimulateQuota(tlr)
+	assert.Equal(t, hubv1.Quota_QUOTA_BLOCKED, quota, "blocked when recently blocked")
+
+	quota, _ = SimulateQuota(tagged("customer", "other"))
+	assert.Equal(t, hubv1.Quota_QUOTA_UNSPECIFIED, quota, "blocks are per tag set")
+
+	_, err = SimulateQuota(nil)
+	assert.Error(t, err)
+}
+
+func TestConsumedRequests(t *testing.T) {
+	reqs := consumedRequests(
+		[]string{"a", "b", "c"},
+		map[string]float32{"b": 2, "c": 0.5, "d": 2})
+	assert.Len(t, reqs, 3)
+
+	// uncorrected tokens first, then one request per weight (each token once)
+	assert.Equal(t, []string{"a"}, reqs[0].GetTokens())
+	assert.Nil(t, reqs[0].WeightCorrection)
+	assert.Equal(t, []string{"c"}, reqs[1].GetTokens())
+	assert.Equal(t, float32(0.5), reqs[1].GetWeightCorrection())
+	assert.ElementsMatch(t, []string{"b", "d"}, reqs[2].GetTokens())
+	assert.Equal(t, float32(2), reqs[2].GetWeightCorrection())
+
+	assert.Empty(t, consumedRequests(nil, nil))
+
+	pending := func(token string) bool {
+		consumedLeasesLock.Lock()
+		defer consumedLeasesLock.Unlock()
+		_, ok := weightCorrections[token]
+		return ok
+	}
+	corrected := func(weight float32, tokens ...string) *hubv1.SetTokenLeaseConsumedRequest {
+		for _, token := range tokens {
+			CorrectWeight(token, weight)
+		}
+		return &hubv1.SetTokenLeaseConsumedRequest{Tokens: tokens, WeightCorrection: proto.Float32(weight)}
+	}
+	unavailable := status.Error(codes.Unavailable, "try again")
+
+	// acknowledged corrections are dropped
+	req := corrected(3, "ack")
+	assert.NoError(t, consumedResult(req, nil))
+	assert.False(t, pending("ack"))
+
+	// rejected ones too, rather than being re-sent forever
+	req = corrected(3, "invalid")
+	assert.Error(t, consumedResult(req, status.Error(codes.InvalidArgument, "unknown token")))
+	assert.False(t, pending("invalid"))
+
+	// others are retried, up to MAX_WEIGHT_CORRECTION_TRIES times
+	req = corrected(3, "retried")
+	for i := 1; i < MAX_WEIGHT_CORRECTION_TRIES; i++ {
+		assert.ErrorIs(t, consumedResult(req, unavailable), unavailable)
+		assert.True(t, pending("retried"))
+	}
+	assert.ErrorContains(t, consumedResult(req, unavailable), "dropped 1 weight corrections")
+	assert.False(t, pending("retried"))
+
+	// a correction which changed since it was sent is kept
+	req = corrected(3, "changed")
+	CorrectWeight("changed", 4)
+	assert.NoError(t, consumedResult(req, nil))
+	assert.True(t, pending("changed"))
+	assert.NoError(t, consumedResult(corrected(4, "changed"), nil))
+}
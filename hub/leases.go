@@ -0,0 +1,199 @@
+package hub
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+)
+
+// leaseCache holds the cached leases of one guard and quota tag set
+type leaseCache struct {
+	lock   sync.Mutex
+	leases []*hubv1.TokenLease
+	used   int                         // leases used (or expired) since the last refill
+	req    *hubv1.GetTokenLeaseRequest // refills the cache
+	tagged bool
+
+	// use a separate "waiting leases" lock as we don't need/want to block a
+	// request on contention for the higher volume "cached leases" lock
+	waitingLock sync.Mutex
+	waiting     []*hubv1.TokenLease
+
+	lastUsed atomic.Int64 // unix nanoseconds
+}
+
+// leaseKey identifies the lease cache for a request, its guard plus its quota
+// tags (in a canonical order)
+func leaseKey(tlr *hubv1.GetTokenLeaseRequest) string {
+	key := tlr.GetSelector().GetGuardName()
+	tags := tlr.GetSelector().GetTags()
+	if len(tags) == 0 {
+		return key
+	}
+	kvs := make([]string, 0, len(tags))
+	for _, t := range tags {
+		kvs = append(kvs, t.GetKey()+"="+t.GetValue())
+	}
+	slices.Sort(kvs)
+	return key + "\x00" + strings.Join(kvs, "\x00")
+}
+
+// getLeaseCache returns the lease cache for a request, creating it if needed.
+// Past MAX_TAGGED_LEASE_CACHES tagged caches, the least recently used one is
+// evicted to make room (along with its unused leases).
+func getLeaseCache(tlr *hubv1.GetTokenLeaseRequest) *leaseCache {
+	key := leaseKey(tlr)
+	leaseCachesLock.RLock()
+	lc, ok := leaseCaches[key]
+	leaseCachesLock.RUnlock()
+	if ok {
+		return lc
+	}
+
+	leaseCachesLock.Lock()
+	defer leaseCachesLock.Unlock()
+	if lc, ok := leaseCaches[key]; ok {
+		return lc
+	}
+	lc = &leaseCache{
+		req: &hubv1.GetTokenLeaseRequest{
+			Selector: &hubv1.GuardFeatureSelector{
+				Environment: tlr.GetSelector().GetEnvironment(),
+				GuardName:   tlr.GetSelector().GetGuardName(),
+				Tags:        tlr.GetSelector().GetTags(),
+			},
+			ClientId: tlr.ClientId,
+		},
+		tagged: len(tlr.GetSelector().GetTags()) > 0,
+	}
+	lc.lastUsed.Store(time.Now().UnixNano())
+	if lc.tagged {
+		if taggedLeaseCaches >= MAX_TAGGED_LEASE_CACHES {
+			evictLeaseCache(lruLeaseCache())
+		}
+		taggedLeaseCaches += 1
+	}
+	leaseCaches[key] = lc
+	return lc
+}
+
+// lruLeaseCache returns the key of the least recently used tagged lease cache
+// (the caller holds leaseCachesLock)
+func lruLeaseCache() string {
+	lru, lruUsed := "", int64(0)
+	for key, lc := range leaseCaches {
+		if used := lc.lastUsed.Load(); lc.tagged && (lru == "" || used < lruUsed) {
+			lru, lruUsed = key, used
+		}
+	}
+	return lru
+}
+
+// evictLeaseCache drops a lease cache (the caller holds leaseCachesLock)
+func evictLeaseCache(key string) {
+	if lc, ok := leaseCaches[key]; ok {
+		if lc.tagged {
+			taggedLeaseCaches -= 1
+		}
+		delete(leaseCaches, key)
+	}
+}
+
+// findLeaseCache returns the lease cache for a request, if there is one
+func findLeaseCache(tlr *hubv1.GetTokenLeaseRequest) *leaseCache {
+	leaseCachesLock.RLock()
+	defer leaseCachesLock.RUnlock()
+	return leaseCaches[leaseKey(tlr)]
+}
+
+// has reports whether take would find a lease, without taking it
+func (lc *leaseCache) has(feature string, priorityBoost int32) bool {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+	for _, tl := range lc.leases {
+		if tl.GetFeature() == feature &&
+			tl.GetPriorityBoost() <= priorityBoost &&
+			time.Now().Before(tl.GetExpiresAt().AsTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// take removes and returns the token of a cached lease for the given feature,
+// at the right priority, which hasn't expired
+func (lc *leaseCache) take(feature string, priorityBoost int32) (string, bool) {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+	for k, tl := range lc.leases {
+		if tl.GetFeature() == feature &&
+			tl.GetPriorityBoost() <= priorityBoost &&
+			time.Now().Before(tl.GetExpiresAt().AsTime()) {
+			if k == 0 {
+				// the usual case, don't shift every other lease down by one
+				lc.leases[0] = nil
+				lc.leases = lc.leases[1:]
+			} else {
+				lc.leases = append(lc.leases[:k], lc.leases[k+1:]...)
+			}
+			lc.used += 1
+			return tl.Token, true
+		}
+	}
+	return "", false
+}
+
+// addWaiting queues leases to be added to the cache (by cachedLeaseManager)
+func (lc *leaseCache) addWaiting(leases []*hubv1.TokenLease) {
+	lc.waitingLock.Lock()
+	lc.waiting = append(lc.waiting, leases...)
+	lc.waitingLock.Unlock()
+}
+
+// refresh drops expired leases and adds waiting ones, returning true if the
+// cache should be refilled (more than 80% of its leases are used or expiring)
+func (lc *leaseCache) refresh() bool {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+
+	// Check for and remove any expired leases
+	now := time.Now()
+	cache := []*hubv1.TokenLease{}
+	for _, tl := range lc.leases {
+		if now.Before(tl.GetExpiresAt().AsTime()) {
+			cache = append(cache, tl)
+		} else {
+			lc.used += 1
+		}
+	}
+
+	// Add any additional leases waiting to be cached now
+	lc.waitingLock.Lock()
+	if len(lc.waiting) > 0 {
+		cache = append(cache, lc.waiting...)
+		lc.used = 0
+		lc.waiting = nil
+	}
+	lc.waitingLock.Unlock()
+	lc.leases = cache
+
+	// Count leases which aren't within 2 seconds of expiring
+	fresh := 0
+	for _, tl := range cache {
+		if now.Before(tl.GetExpiresAt().AsTime().Add(-2 * time.Second)) {
+			fresh += 1
+		}
+	}
+	total := len(cache) + lc.used
+	return total > 0 && float32(fresh)/float32(total) < 0.2
+}
+
+// idle reports whether a tagged lease cache hasn't been used for a while (the
+// untagged, guard wide, caches are kept)
+func (lc *leaseCache) idle() bool {
+	return lc.tagged && time.Since(time.Unix(0, lc.lastUsed.Load())) > IDLE_LEASE_CACHE_TIMEOUT
+}
@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func tagged(tags ...string) *hubv1.GetTokenLeaseRequest {
+	tlr := &hubv1.GetTokenLeaseRequest{Selector: &hubv1.GuardFeatureSelector{GuardName: "guard"}}
+	for i := 0; i < len(tags); i += 2 {
+		tlr.Selector.Tags = append(tlr.Selector.Tags, &hubv1.Tag{Key: tags[i], Value: tags[i+1]})
+	}
+	return tlr
+}
+
+func TestLeaseKey(t *testing.T) {
+	assert.Equal(t, "guard", leaseKey(tagged()))
+	assert.Equal(t, leaseKey(tagged("a", "1", "b", "2")), leaseKey(tagged("b", "2", "a", "1")), "tag order doesn't matter")
+	assert.NotEqual(t, leaseKey(tagged("a", "1")), leaseKey(tagged("a", "2")))
+}
+
+func TestLeaseCache(t *testing.T) {
+	lc := getLeaseCache(tagged("customer", "acme"))
+	assert.Same(t, lc, getLeaseCache(tagged("customer", "acme")))
+	assert.NotSame(t, lc, getLeaseCache(tagged("customer", "other")))
+
+	expires := timestamppb.New(time.Now().Add(time.Minute))
+	lc.addWaiting([]*hubv1.TokenLease{
+		{Token: "t1", Feature: "feat", ExpiresAt: expires},
+		{Token: "t2", Feature: "feat", ExpiresAt: expires},
+	})
+	_, ok := lc.take("feat", 0)
+	assert.False(t, ok, "waiting leases aren't used until refreshed")
+
+	assert.False(t, lc.refresh(), "no refill needed")
+	token, ok := lc.take("feat", 0)
+	assert.True(t, ok)
+	assert.Equal(t, "t1", token)
+	_, ok = lc.take("other", 0)
+	assert.False(t, ok, "leases are per feature")
+	lc.take("feat", 0)
+	assert.True(t, lc.refresh(), "refill once leases are used")
+
+	assert.False(t, lc.idle())
+	lc.lastUsed.Store(time.Now().Add(-2 * IDLE_LEASE_CACHE_TIMEOUT).UnixNano())
+	assert.True(t, lc.idle(), "tagged caches are evicted when idle")
+}
+
+func TestLeaseCacheEviction(t *testing.T) {
+	leaseCachesLock.Lock()
+	for key := range leaseCaches {
+		evictLeaseCache(key)
+	}
+	leaseCachesLock.Unlock()
+
+	untagged := getLeaseCache(tagged())
+	first := getLeaseCache(tagged("customer", "0"))
+	for i := 1; i < MAX_TAGGED_LEASE_CACHES; i++ {
+		lc := getLeaseCache(tagged("customer", strconv.Itoa(i)))
+		lc.lastUsed.Store(time.Now().Add(time.Duration(i) * time.Millisecond).UnixNano())
+	}
+	first.lastUsed.Store(time.Now().Add(time.Hour).UnixNano()) // recently used
+	assert.Equal(t, MAX_TAGGED_LEASE_CACHES, taggedLeaseCaches)
+
+	// the least recently used tagged cache makes room, the others stay
+	getLeaseCache(tagged("customer", "new"))
+	assert.Equal(t, MAX_TAGGED_LEASE_CACHES, taggedLeaseCaches)
+	assert.Nil(t, findLeaseCache(tagged("customer", "1")))
+	assert.Same(t, first, findLeaseCache(tagged("customer", "0")))
+	assert.Same(t, untagged, findLeaseCache(tagged()), "untagged caches are never evicted")
+	assert.NotNil(t, findLeaseCache(tagged("customer", "2")))
+}
@@ -0,0 +1,236 @@
+// Package hubtest provides a fake Stanza Hub, for tests and benchmarks which
+// need the real guard pipeline (config, token, and quota checks) without a
+// real hub.
+package hubtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"buf.build/gen/go/stanza/apis/grpc/go/stanza/hub/v1/hubv1grpc"
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/global"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// How many leases each GetTokenLease response carries (by default)
+	DEFAULT_LEASES = 1000
+
+	// Ingress tokens with this value are never valid
+	INVALID_TOKEN = "invalid"
+
+	CUSTOMER_ID  = "hubtest-customer"
+	BEARER_TOKEN = "hubtest-bearer-token"
+)
+
+// Hub is a fake Stanza Hub serving the auth, config, and quota services
+type Hub struct {
+	hubv1grpc.UnimplementedAuthServiceServer
+	hubv1grpc.UnimplementedConfigServiceServer
+	hubv1grpc.UnimplementedQuotaServiceServer
+
+	lock       sync.Mutex
+	collector  string // OTEL collector sent with the service config (if any)
+	svcVersion int    // last service config version
+	guards     map[string]*hubv1.GuardConfig
+	versions   map[string]string
+	blocked    map[string]bool // guards with no quota left
+	leases     int
+	version    int // last guard config version
+	tokens     int // last lease token
+
+	// requests served, by method name
+	calls sync.Map
+
+	server   *grpc.Server
+	listener net.Listener
+}
+
+var (
+	started     *Hub
+	startedErr  error
+	startedOnce sync.Once
+)
+
+// Start starts a fake hub (on a loopback port, without TLS) and initializes
+// the SDK global state to use it. The global state can only be initialized
+// once per process, so every caller gets the same hub.
+func Start() (*Hub, error) {
+	startedOnce.Do(func() {
+		started, startedErr = NewHub()
+		if startedErr != nil {
+			return
+		}
+		os.Setenv("STANZA_HUB_NO_TLS", "true")
+		_, startedErr = global.NewState(context.Background(), []string{started.Addr()},
+			"hubtest-key", nil, "hubtest", "test", "0.0.0", nil)
+	})
+	return started, startedErr
+}
+
+// NewHub starts a fake hub, without pointing the SDK at it (see Start)
+func NewHub() (*Hub, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	h := &Hub{
+		guards:     map[string]*hubv1.GuardConfig{},
+		versions:   map[string]string{},
+		blocked:    map[string]bool{},
+		leases:     DEFAULT_LEASES,
+		svcVersion: 1,
+		server:     grpc.NewServer(),
+		listener:   lis,
+	}
+	hubv1grpc.RegisterAuthServiceServer(h.server, h)
+	hubv1grpc.RegisterConfigServiceServer(h.server, h)
+	hubv1grpc.RegisterQuotaServiceServer(h.server, h)
+	go h.server.Serve(lis)
+	return h, nil
+}
+
+// Addr returns the "host:port" the hub is listening on
+func (h *Hub) Addr() string {
+	return h.listener.Addr().String()
+}
+
+// Close stops the hub
+func (h *Hub) Close() {
+	h.server.Stop()
+}
+
+// SetCollector sets the OTEL metric and trace collector sent with the service
+// config (with a new version), an empty url sends no OTEL configs
+func (h *Hub) SetCollector(url string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.svcVersion += 1
+	h.collector = url
+}
+
+// SetGuardConfig sets (or replaces) a guard's config, with a new version
+func (h *Hub) SetGuardConfig(guard string, gc *hubv1.GuardConfig) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.version += 1
+	h.guards[guard] = gc
+	h.versions[guard] = strconv.Itoa(h.version)
+}
+
+// SetBlocked makes GetTokenLease return no leases (quota exhausted) for guard
+func (h *Hub) SetBlocked(guard string, blocked bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.blocked[guard] = blocked
+}
+
+// SetLeases sets how many leases each GetTokenLease response carries
+func (h *Hub) SetLeases(n int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.leases = n
+}
+
+// Calls returns how many times method (e.g. "GetTokenLease") was called
+func (h *Hub) Calls(method string) int64 {
+	if n, ok := h.calls.Load(method); ok {
+		return n.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+func (h *Hub) called(method string) {
+	n, _ := h.calls.LoadOrStore(method, &atomic.Int64{})
+	n.(*atomic.Int64).Add(1)
+}
+
+func (h *Hub) GetBearerToken(ctx context.Context, req *hubv1.GetBearerTokenRequest) (*hubv1.GetBearerTokenResponse, error) {
+	h.called("GetBearerToken")
+	return &hubv1.GetBearerTokenResponse{BearerToken: BEARER_TOKEN}, nil
+}
+
+func (h *Hub) GetServiceConfig(ctx context.Context, req *hubv1.GetServiceConfigRequest) (*hubv1.GetServiceConfigResponse, error) {
+	h.called("GetServiceConfig")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	version := strconv.Itoa(h.svcVersion)
+	if req.GetVersionSeen() == version {
+		return &hubv1.GetServiceConfigResponse{ConfigDataSent: false}, nil
+	}
+	sc := &hubv1.ServiceConfig{CustomerId: proto.String(CUSTOMER_ID)}
+	if h.collector != "" {
+		sc.MetricConfig = &hubv1.MetricConfig{CollectorUrl: proto.String(h.collector)}
+		sc.TraceConfig = &hubv1.TraceConfig{
+			CollectorUrl:      proto.String(h.collector),
+			SampleRateDefault: proto.Float32(0),
+		}
+	}
+	return &hubv1.GetServiceConfigResponse{
+		Version:        version,
+		ConfigDataSent: true,
+		Config:         sc,
+	}, nil
+}
+
+func (h *Hub) GetGuardConfig(ctx context.Context, req *hubv1.GetGuardConfigRequest) (*hubv1.GetGuardConfigResponse, error) {
+	h.called("GetGuardConfig")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	guard := req.GetSelector().GetGuardName()
+	gc, ok := h.guards[guard]
+	if !ok || req.GetVersionSeen() == h.versions[guard] {
+		return &hubv1.GetGuardConfigResponse{ConfigDataSent: false}, nil
+	}
+	return &hubv1.GetGuardConfigResponse{
+		Version:        h.versions[guard],
+		ConfigDataSent: true,
+		Config:         proto.Clone(gc).(*hubv1.GuardConfig),
+	}, nil
+}
+
+func (h *Hub) GetTokenLease(ctx context.Context, req *hubv1.GetTokenLeaseRequest) (*hubv1.GetTokenLeaseResponse, error) {
+	h.called("GetTokenLease")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.blocked[req.GetSelector().GetGuardName()] {
+		return &hubv1.GetTokenLeaseResponse{}, nil
+	}
+	resp := &hubv1.GetTokenLeaseResponse{}
+	for i := 0; i < h.leases; i++ {
+		h.tokens += 1
+		resp.Leases = append(resp.Leases, &hubv1.TokenLease{
+			Token:         "token-" + strconv.Itoa(h.tokens),
+			Feature:       req.GetSelector().GetFeatureName(),
+			PriorityBoost: req.GetPriorityBoost(),
+			Weight:        1,
+			DurationMsec:  10000,
+		})
+	}
+	return resp, nil
+}
+
+func (h *Hub) SetTokenLeaseConsumed(ctx context.Context, req *hubv1.SetTokenLeaseConsumedRequest) (*hubv1.SetTokenLeaseConsumedResponse, error) {
+	h.called("SetTokenLeaseConsumed")
+	return &hubv1.SetTokenLeaseConsumedResponse{}, nil
+}
+
+func (h *Hub) ValidateToken(ctx context.Context, req *hubv1.ValidateTokenRequest) (*hubv1.ValidateTokenResponse, error) {
+	h.called("ValidateToken")
+	resp := &hubv1.ValidateTokenResponse{}
+	for _, t := range req.GetTokens() {
+		resp.TokensValid = append(resp.TokensValid, &hubv1.TokenValid{
+			Token: t.GetToken(),
+			Valid: t.GetToken() != INVALID_TOKEN,
+		})
+	}
+	return resp, nil
+}
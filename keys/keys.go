@@ -7,8 +7,11 @@ const (
 	StzFeat  = "stz-feat"
 )
 
-var (
+// constants, so using them as context keys doesn't allocate
+const (
 	OutboundHeadersKey = ContextKey("stanza-outbound-headers")
+	ShadowKey          = ContextKey("stanza-shadow")
+	TagsKey            = ContextKey("stanza-tags")
 	UberctxStzBoostKey = ContextKey("uberctx-" + StzBoost)
 	UberctxStzFeatKey  = ContextKey("uberctx-" + StzFeat)
 	OtStzBoostKey      = ContextKey("ot-baggage-" + StzBoost)
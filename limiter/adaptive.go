@@ -0,0 +1,259 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Algorithm computes a new concurrency limit from each completed request,
+// implementations don't need to be safe for concurrent use (Adaptive
+// serializes calls to Update)
+type Algorithm interface {
+	// InitialLimit returns the limit to start with
+	InitialLimit() int
+
+	// Update returns the new limit given the current limit, the rtt of a
+	// completed request, how many requests were in flight when it started, and
+	// whether it failed or was dropped
+	Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// Adaptive is a Limiter whose limit is adjusted by an Algorithm, based on the
+// latency and failures of completed requests
+type Adaptive struct {
+	mu       sync.Mutex
+	alg      Algorithm
+	limit    float64
+	inflight int
+}
+
+// NewAdaptive returns a Limiter driven by alg (each Adaptive needs its own alg)
+func NewAdaptive(alg Algorithm) *Adaptive {
+	return &Adaptive{
+		alg:   alg,
+		limit: float64(alg.InitialLimit()),
+	}
+}
+
+func (a *Adaptive) Acquire() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.inflight >= int(a.limit) {
+		return false
+	}
+	a.inflight += 1
+	return true
+}
+
+func (a *Adaptive) Release(rtt time.Duration, dropped bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inflight := a.inflight
+	a.inflight -= 1
+	if rtt > 0 || dropped {
+		a.limit = a.alg.Update(a.limit, rtt, inflight, dropped)
+	}
+}
+
+func (a *Adaptive) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+func (a *Adaptive) InFlight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.inflight
+}
+
+func clamp(limit float64, min, max int) float64 {
+	return math.Max(float64(min), math.Min(float64(max), limit))
+}
+
+// AIMDOptions configures an AIMD Algorithm, zero values use the defaults
+type AIMDOptions struct {
+	InitialLimit int           // default: 20
+	MinLimit     int           // default: 1
+	MaxLimit     int           // default: 200
+	BackoffRatio float64       // multiplies the limit on failure (default: 0.9)
+	Timeout      time.Duration // requests slower than this count as failures (default: 5s)
+}
+
+type aimd struct {
+	AIMDOptions
+}
+
+// NewAIMD returns an additive increase, multiplicative decrease Algorithm. The
+// limit grows by one for every successful request (while at least half of the
+// limit is in use) and backs off on failures and timeouts.
+func NewAIMD(o AIMDOptions) Algorithm {
+	if o.InitialLimit <= 0 {
+		o.InitialLimit = 20
+	}
+	if o.MinLimit <= 0 {
+		o.MinLimit = 1
+	}
+	if o.MaxLimit <= 0 {
+		o.MaxLimit = 200
+	}
+	if o.BackoffRatio <= 0 || o.BackoffRatio >= 1 {
+		o.BackoffRatio = 0.9
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	return &aimd{o}
+}
+
+func (a *aimd) InitialLimit() int {
+	return a.AIMDOptions.InitialLimit
+}
+
+func (a *aimd) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped || rtt > a.Timeout {
+		limit = math.Floor(limit * a.BackoffRatio)
+	} else if float64(inflight*2) >= limit {
+		limit += 1
+	}
+	return clamp(limit, a.MinLimit, a.MaxLimit)
+}
+
+// VegasOptions configures a Vegas Algorithm, zero values use the defaults
+type VegasOptions struct {
+	InitialLimit int     // default: 20
+	MaxLimit     int     // default: 1000
+	Smoothing    float64 // 0 < Smoothing <= 1, how much of a change is applied at once (default: 1)
+}
+
+type vegas struct {
+	VegasOptions
+	rttNoLoad time.Duration
+}
+
+// NewVegas returns a delay based Algorithm (modeled on TCP Vegas). It
+// estimates the queue size from the ratio of the lowest observed rtt to the
+// current rtt, growing the limit while the queue is small and shrinking it as
+// the queue (or failures) grow.
+func NewVegas(o VegasOptions) Algorithm {
+	if o.InitialLimit <= 0 {
+		o.InitialLimit = 20
+	}
+	if o.MaxLimit <= 0 {
+		o.MaxLimit = 1000
+	}
+	if o.Smoothing <= 0 || o.Smoothing > 1 {
+		o.Smoothing = 1
+	}
+	return &vegas{VegasOptions: o}
+}
+
+func (v *vegas) InitialLimit() int {
+	return v.VegasOptions.InitialLimit
+}
+
+func (v *vegas) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if rtt > 0 && (v.rttNoLoad == 0 || rtt < v.rttNoLoad) {
+		v.rttNoLoad = rtt
+	}
+	log := math.Max(1, math.Log10(limit))
+
+	var newLimit float64
+	if dropped {
+		newLimit = limit - log
+	} else if float64(inflight*2) < limit {
+		return limit // not using enough of the limit to learn anything
+	} else {
+		queueSize := math.Ceil(limit * (1 - float64(v.rttNoLoad)/float64(rtt)))
+		alpha, beta := 3*log, 6*log
+		switch {
+		case queueSize <= log:
+			newLimit = limit + beta
+		case queueSize < alpha:
+			newLimit = limit + log
+		case queueSize > beta:
+			newLimit = limit - log
+		default:
+			return limit
+		}
+	}
+	newLimit = clamp(newLimit, 1, v.MaxLimit)
+	return (1-v.Smoothing)*limit + v.Smoothing*newLimit
+}
+
+// Gradient2Options configures a Gradient2 Algorithm, zero values use the defaults
+type Gradient2Options struct {
+	InitialLimit int     // default: 20
+	MinLimit     int     // default: 1
+	MaxLimit     int     // default: 200
+	Smoothing    float64 // 0 < Smoothing <= 1, how much of a change is applied at once (default: 0.2)
+	Tolerance    float64 // how much the short term rtt may exceed the long term average (default: 1.5)
+	QueueSize    int     // headroom added to the limit (default: 4)
+	LongWindow   int     // samples in the long term rtt average (default: 600)
+}
+
+type gradient2 struct {
+	Gradient2Options
+	longRtt float64 // exponential moving average, in nanoseconds
+	samples int
+}
+
+// NewGradient2 returns a gradient based Algorithm (modeled on Netflix
+// Gradient2). It compares the latest rtt with a long term moving average and
+// shrinks the limit in proportion as latency rises, failures count as a
+// maximal rise.
+func NewGradient2(o Gradient2Options) Algorithm {
+	if o.InitialLimit <= 0 {
+		o.InitialLimit = 20
+	}
+	if o.MinLimit <= 0 {
+		o.MinLimit = 1
+	}
+	if o.MaxLimit <= 0 {
+		o.MaxLimit = 200
+	}
+	if o.Smoothing <= 0 || o.Smoothing > 1 {
+		o.Smoothing = 0.2
+	}
+	if o.Tolerance < 1 {
+		o.Tolerance = 1.5
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 4
+	}
+	if o.LongWindow <= 0 {
+		o.LongWindow = 600
+	}
+	return &gradient2{Gradient2Options: o}
+}
+
+func (g *gradient2) InitialLimit() int {
+	return g.Gradient2Options.InitialLimit
+}
+
+func (g *gradient2) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	gradient := 0.5 // failures shrink the limit as much as a doubling of rtt
+	if !dropped {
+		shortRtt := float64(rtt)
+		if g.samples < 10 { // warm up with a simple average
+			g.samples += 1
+			g.longRtt += (shortRtt - g.longRtt) / float64(g.samples)
+		} else {
+			g.longRtt += (shortRtt - g.longRtt) * 2 / float64(g.LongWindow+1)
+		}
+
+		// recover faster from a long term average inflated by an outage
+		if g.longRtt/shortRtt > 2 {
+			g.longRtt *= 0.95
+		}
+
+		if float64(inflight) < limit/2 {
+			return limit // not using enough of the limit to learn anything
+		}
+		gradient = math.Max(0.5, math.Min(1, g.Tolerance*g.longRtt/shortRtt))
+	}
+	newLimit := limit*gradient + float64(g.QueueSize)
+	newLimit = limit*(1-g.Smoothing) + newLimit*g.Smoothing
+	return clamp(newLimit, g.MinLimit, g.MaxLimit)
+}
@@ -0,0 +1,102 @@
+package limiter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveAcquire(t *testing.T) {
+	l := NewAdaptive(NewAIMD(AIMDOptions{InitialLimit: 2}))
+	assert.True(t, l.Acquire())
+	assert.True(t, l.Acquire())
+	assert.False(t, l.Acquire(), "acquire over the limit")
+	assert.Equal(t, 2, l.InFlight())
+
+	l.Release(0, false) // no sample, limit unchanged
+	assert.Equal(t, 1, l.InFlight())
+	assert.Equal(t, 2, l.Limit())
+}
+
+func TestAIMD(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		limit    float64
+		rtt      time.Duration
+		inflight int
+		dropped  bool
+		want     float64
+	}{
+		{"additive increase", 10, ms, 5, false, 11},
+		{"capped at max limit", 11, ms, 6, false, 11},
+		{"unchanged when under used", 10, ms, 4, false, 10},
+		{"multiplicative decrease", 10, ms, 5, true, 9},
+		{"timeouts count as failures", 10, 2 * time.Second, 5, false, 9},
+		{"floored at min limit", 1, ms, 1, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg := NewAIMD(AIMDOptions{InitialLimit: 10, MaxLimit: 11, Timeout: time.Second})
+			assert.Equal(t, tt.want, alg.Update(tt.limit, tt.rtt, tt.inflight, tt.dropped))
+		})
+	}
+}
+
+func TestVegas(t *testing.T) {
+	ms := time.Millisecond
+	log := math.Log10(20)
+	tests := []struct {
+		name     string
+		rtt      time.Duration // after a 10ms no load rtt
+		inflight int
+		dropped  bool
+		want     float64
+	}{
+		{"grows by beta without a queue", 10 * ms, 20, false, 20 + 6*log},
+		{"grows by log with a small queue", 11500 * time.Microsecond, 20, false, 20 + log},
+		{"unchanged with a moderate queue", 13 * ms, 20, false, 20},
+		{"shrinks with a large queue", 20 * ms, 20, false, 20 - log},
+		{"unchanged when under used", 20 * ms, 5, false, 20},
+		{"shrinks on failure", 0, 20, true, 20 - log},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg := NewVegas(VegasOptions{})
+			alg.Update(20, 10*ms, 0, false) // learn the no load rtt
+			assert.InDelta(t, tt.want, alg.Update(20, tt.rtt, tt.inflight, tt.dropped), 0.001)
+		})
+	}
+
+	alg := NewVegas(VegasOptions{MaxLimit: 25})
+	assert.Equal(t, 25.0, alg.Update(20, 10*ms, 20, false), "capped at max limit")
+}
+
+func TestGradient2(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		opts     Gradient2Options
+		limit    float64
+		rtt      time.Duration // after a 10ms sample
+		inflight int
+		dropped  bool
+		want     float64
+	}{
+		{"grows by the queue size with steady latency", Gradient2Options{Smoothing: 1}, 20, 10 * ms, 20, false, 24},
+		{"shrinks when latency rises", Gradient2Options{Smoothing: 1}, 20, 200 * ms, 20, false, 0.7875*20 + 4},
+		{"shrinks on failure", Gradient2Options{Smoothing: 1}, 20, 0, 20, true, 14},
+		{"unchanged when under used", Gradient2Options{Smoothing: 1}, 20, 10 * ms, 5, false, 20},
+		{"capped at max limit", Gradient2Options{Smoothing: 1}, 200, 10 * ms, 200, false, 200},
+		{"smoothed", Gradient2Options{}, 20, 10 * ms, 20, false, 0.8*20 + 0.2*24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg := NewGradient2(tt.opts)
+			alg.Update(tt.limit, 10*ms, 0, false) // seed the long term rtt
+			assert.InDelta(t, tt.want, alg.Update(tt.limit, tt.rtt, tt.inflight, tt.dropped), 0.001)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Package limiter bounds the number of guarded requests which are in flight
+// (between Handler.Guard and Guard.End) at the same time.
+package limiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Limiter bounds the number of concurrent in-flight requests
+type Limiter interface {
+	// Acquire reserves an in-flight slot, returning false if the limit has
+	// been reached (in which case nothing needs to be released)
+	Acquire() bool
+
+	// Release frees a slot reserved by Acquire. rtt is how long the slot was
+	// held (zero if the slot was never used, like when a later guard check
+	// blocked) and dropped reports whether the work failed or was abandoned.
+	Release(rtt time.Duration, dropped bool)
+
+	// Limit returns the current concurrency limit
+	Limit() int
+
+	// InFlight returns the number of currently reserved slots
+	InFlight() int
+}
+
+// Fixed is a Limiter with a constant concurrency limit
+type Fixed struct {
+	limit    int64
+	inflight atomic.Int64
+}
+
+// NewFixed returns a Limiter which allows up to limit concurrent requests
+func NewFixed(limit int) *Fixed {
+	return &Fixed{limit: int64(limit)}
+}
+
+func (f *Fixed) Acquire() bool {
+	for {
+		n := f.inflight.Load()
+		if n >= f.limit {
+			return false
+		}
+		if f.inflight.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func (f *Fixed) Release(rtt time.Duration, dropped bool) {
+	f.inflight.Add(-1)
+}
+
+func (f *Fixed) Limit() int {
+	return int(f.limit)
+}
+
+func (f *Fixed) InFlight() int {
+	return int(f.inflight.Load())
+}
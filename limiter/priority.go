@@ -0,0 +1,57 @@
+package limiter
+
+// Local priorities, higher is more important. A request's priority is its
+// feature's base priority (PriorityNormal by default) plus its PriorityBoost.
+const (
+	PriorityBackground = iota
+	PriorityLow
+	PriorityNormal
+	PriorityCritical
+)
+
+// Names of the priority bands, indexed by priority
+var Bands = []string{"background", "low", "normal", "critical"}
+
+// Share of capacity (in-flight limit or CPU threshold) each priority band may
+// use before it is shed, indexed by priority
+var DEFAULT_BAND_SHARES = []float64{0.5, 0.75, 0.9, 1.0}
+
+// Shedder sheds the lowest priority traffic first as a guard gets overloaded.
+// Each priority band may only use its share of capacity, leaving the rest for
+// more important traffic.
+type Shedder struct {
+	Limits       bool           // shed by in-flight limits (capacity is the limit)
+	CPUThreshold float64        // process CPU usage (0-1) at full capacity, 0 to ignore CPU
+	CPUUsage     func() float64 // current process CPU usage (0-1), negative if unknown
+}
+
+// Band returns the priority band for a priority
+func Band(priority int32) int {
+	if priority < PriorityBackground {
+		return PriorityBackground
+	}
+	if priority > PriorityCritical {
+		return PriorityCritical
+	}
+	return int(priority)
+}
+
+// Admit reports whether a request in band should be admitted, given the
+// in-flight limiters which apply to it
+func (s *Shedder) Admit(band int, ls []Limiter) bool {
+	share := DEFAULT_BAND_SHARES[band]
+	if share >= 1 {
+		return true // never shed, only limited
+	}
+	for _, l := range ls {
+		if s.Limits && float64(l.InFlight()) >= share*float64(l.Limit()) {
+			return false
+		}
+	}
+	if s.CPUThreshold > 0 && s.CPUUsage != nil {
+		if cpu := s.CPUUsage(); cpu >= 0 && cpu >= share*s.CPUThreshold {
+			return false
+		}
+	}
+	return true
+}
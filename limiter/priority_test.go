@@ -0,0 +1,44 @@
+package limiter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBand(t *testing.T) {
+	assert.Equal(t, PriorityBackground, Band(-3))
+	assert.Equal(t, PriorityLow, Band(PriorityLow))
+	assert.Equal(t, PriorityCritical, Band(PriorityCritical+2))
+}
+
+func TestShedderAdmit(t *testing.T) {
+	tests := []struct {
+		name     string
+		shedder  Shedder
+		inflight int // of a limit of 20
+		cpu      float64
+		admitted []bool // by band
+	}{
+		{"idle", Shedder{Limits: true}, 0, 0, []bool{true, true, true, true}},
+		{"background shed first", Shedder{Limits: true}, 10, 0, []bool{false, true, true, true}},
+		{"then low", Shedder{Limits: true}, 15, 0, []bool{false, false, true, true}},
+		{"then normal", Shedder{Limits: true}, 18, 0, []bool{false, false, false, true}},
+		{"critical is only limited", Shedder{Limits: true}, 20, 0, []bool{false, false, false, true}},
+		{"limits ignored without Limits", Shedder{}, 18, 0, []bool{true, true, true, true}},
+		{"cpu", Shedder{CPUThreshold: 0.8}, 0, 0.65, []bool{false, false, true, true}},
+		{"unknown cpu", Shedder{CPUThreshold: 0.8}, 0, -1, []bool{true, true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewFixed(20)
+			for i := 0; i < tt.inflight; i++ {
+				l.Acquire()
+			}
+			tt.shedder.CPUUsage = func() float64 { return tt.cpu }
+			for band, want := range tt.admitted {
+				assert.Equal(t, want, tt.shedder.Admit(band, []Limiter{l}), Bands[band])
+			}
+		})
+	}
+}
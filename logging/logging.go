@@ -10,11 +10,17 @@ import (
 
 // Debug prints messages about all internal changes in the SDK.
 func Debug(msg string, keysAndValues ...interface{}) {
-	if os.Getenv("STANZA_DEBUG") != "" || os.Getenv("STANZA_DEBUG_LOGGING") != "" {
+	if DebugEnabled() {
 		zap.S().Debugw(msg, keysAndValues...)
 	}
 }
 
+// DebugEnabled reports whether Debug prints anything, check it before building
+// expensive keysAndValues.
+func DebugEnabled() bool {
+	return os.Getenv("STANZA_DEBUG") != "" || os.Getenv("STANZA_DEBUG_LOGGING") != ""
+}
+
 // Info prints messages about the general state of the SDK.
 func Info(msg string, keysAndValues ...interface{}) {
 	zap.S().Infow(msg, keysAndValues...)
@@ -0,0 +1,35 @@
+package stanza
+
+import (
+	"context"
+
+	"github.com/StanzaSystems/sdk-go/handlers"
+)
+
+// Check is a guard pipeline stage (see WithCheck and WithChecks)
+type Check = handlers.Check
+
+// CheckResult is returned by a Check
+type CheckResult = handlers.CheckResult
+
+// Decision is the outcome of a Check
+type Decision = handlers.Decision
+
+const (
+	CheckAllow    = handlers.CheckAllow    // continue with the next check
+	CheckBlock    = handlers.CheckBlock    // block the request
+	CheckFailOpen = handlers.CheckFailOpen // stop checking and allow the request
+)
+
+// Built-in checks, in their default order
+var (
+	ConfigCheck = handlers.ConfigCheck
+	LocalCheck  = handlers.LocalCheck
+	TokenCheck  = handlers.TokenCheck
+	QuotaCheck  = handlers.QuotaCheck
+)
+
+// CheckFunc adapts a function to a Check
+func CheckFunc(name string, fn func(ctx context.Context, g *handlers.Guard) CheckResult) Check {
+	return handlers.CheckFunc(name, fn)
+}
@@ -0,0 +1,53 @@
+package stanza
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/logging"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Do runs fn under the named guard. If the guard blocks, fn is not run and a
+// *BlockedError is returned. Otherwise fn's outcome is recorded automatically:
+// Success, Failure (see WithClassifier), or Failure if fn panics (the panic is
+// re-raised after recording), along with the duration of fn.
+func Do[T any](ctx context.Context, guardName string, fn func(context.Context) (T, error), opts ...Option) (T, error) {
+	var result T
+	h, err := guardHandler(guardName, opts...)
+	if err != nil {
+		logging.Error(err)
+		return result, err
+	}
+	ctx, span := h.Tracer().Start(ctx, guardName, trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+
+	guard := h.Guard(ctx, span, nil)
+	if guard.Blocked() {
+		span.SetStatus(codes.Error, guard.BlockMessage())
+		return result, guard.BlockError()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			span.SetStatus(codes.Error, fmt.Sprintf("panic: %v", r))
+			guard.End(guard.Failure)
+			panic(r)
+		}
+	}()
+	result, err = fn(guard.Context())
+	if h.IsFailure(err) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		guard.EndWith(handlers.Outcome{Status: guard.Failure, Err: err})
+	} else {
+		span.SetStatus(codes.Ok, "OK")
+		guard.EndWith(handlers.Outcome{Status: guard.Success, Err: err})
+	}
+	return result, err
+}
@@ -0,0 +1,134 @@
+package stanza
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+	"github.com/StanzaSystems/sdk-go/limiter"
+	"github.com/stretchr/testify/assert"
+)
+
+// outcomes records whether each guard ended by Do was a failure, as seen by
+// an adaptive limit
+type outcomes struct {
+	mu      sync.Mutex
+	dropped []bool
+}
+
+func (o *outcomes) InitialLimit() int { return 10 }
+
+func (o *outcomes) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.dropped = append(o.dropped, dropped)
+	return limit
+}
+
+func (o *outcomes) get() []bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]bool{}, o.dropped...)
+}
+
+func TestDoBlocked(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetGuardConfig("do-blocked", &hubv1.GuardConfig{CheckQuota: true})
+	hub.SetBlocked("do-blocked", true)
+
+	called := false
+	result, err := Do(context.Background(), "do-blocked", func(ctx context.Context) (string, error) {
+		called = true
+		return "ok", nil
+	})
+	assert.False(t, called)
+	assert.Equal(t, "", result)
+	assert.True(t, errors.Is(err, ErrBlocked))
+	assert.True(t, errors.Is(err, ErrQuotaExhausted))
+	var blocked *BlockedError
+	if assert.True(t, errors.As(err, &blocked)) {
+		assert.Equal(t, "do-blocked", blocked.Guard)
+		assert.Equal(t, ReasonQuotaBlocked, blocked.Reason)
+	}
+}
+
+func TestDoOutcome(t *testing.T) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFailed := errors.New("failed")
+	errIgnored := errors.New("ignored")
+	classifier := WithClassifier(func(err error) bool {
+		return err != nil && !errors.Is(err, errIgnored)
+	})
+
+	tests := []struct {
+		name   string
+		fn     func(context.Context) (int, error)
+		opts   []Option
+		result int
+		err    error
+		panics bool
+		failed bool
+	}{
+		{
+			name:   "success",
+			fn:     func(context.Context) (int, error) { return 1, nil },
+			result: 1,
+		},
+		{
+			name:   "failure",
+			fn:     func(context.Context) (int, error) { return 0, errFailed },
+			err:    errFailed,
+			failed: true,
+		},
+		{
+			name:   "panic",
+			fn:     func(context.Context) (int, error) { panic(errFailed) },
+			panics: true,
+			failed: true,
+		},
+		{
+			name:   "classified_success",
+			fn:     func(context.Context) (int, error) { return 2, errIgnored },
+			opts:   []Option{classifier},
+			result: 2,
+			err:    errIgnored,
+		},
+		{
+			name:   "classified_failure",
+			fn:     func(context.Context) (int, error) { return 0, errFailed },
+			opts:   []Option{classifier},
+			err:    errFailed,
+			failed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// limiters are shared by guard name, so each case gets its own guard
+			guard := "do-outcome-" + tt.name
+			hub.SetGuardConfig(guard, &hubv1.GuardConfig{})
+			o := &outcomes{}
+			opts := append([]Option{WithAdaptiveLimit(func() limiter.Algorithm { return o })}, tt.opts...)
+			do := func() (int, error) {
+				return Do(context.Background(), guard, tt.fn, opts...)
+			}
+			if tt.panics {
+				assert.PanicsWithValue(t, errFailed, func() { do() })
+			} else {
+				result, err := do()
+				assert.Equal(t, tt.result, result)
+				assert.Equal(t, tt.err, err)
+			}
+			assert.Equal(t, []bool{tt.failed}, o.get())
+		})
+	}
+}
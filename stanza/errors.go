@@ -0,0 +1,51 @@
+package stanza
+
+import "github.com/StanzaSystems/sdk-go/handlers"
+
+// Errors returned for blocked requests, use errors.Is to match them
+var (
+	ErrBlocked        = handlers.ErrBlocked        // any blocked request
+	ErrQuotaExhausted = handlers.ErrQuotaExhausted // blocked by Stanza Hub quota
+	ErrLocalBlocked   = handlers.ErrLocalBlocked   // blocked by a local (Sentinel) rule
+	ErrInvalidToken   = handlers.ErrInvalidToken   // blocked by ingress token validation
+
+	ErrConcurrencyLimited = handlers.ErrConcurrencyLimited // blocked by a concurrency limit
+	ErrPriorityShed       = handlers.ErrPriorityShed       // shed as low priority traffic under local overload
+	ErrCheckBlocked       = handlers.ErrCheckBlocked       // blocked by a custom Check
+)
+
+// Block reasons, as returned by Guard.BlockReason
+var (
+	ReasonLocalBlocked  = handlers.ReasonLocalBlocked
+	ReasonTokenNotValid = handlers.ReasonTokenNotValid
+	ReasonQuotaBlocked  = handlers.ReasonQuotaBlocked
+)
+
+// Guard stages, as reported by FailOpenError
+const (
+	StageInit   = handlers.StageInit
+	StageConfig = handlers.StageConfig
+	StageLocal  = handlers.StageLocal
+	StageToken  = handlers.StageToken
+	StageQuota  = handlers.StageQuota
+)
+
+// BlockedError is returned for requests which were blocked by a guard
+type BlockedError = handlers.BlockedError
+
+// LocalBlockedError wraps the Sentinel BlockError of a local rule block
+type LocalBlockedError = handlers.LocalBlockedError
+
+// CheckBlockedError names the custom Check which blocked a request
+type CheckBlockedError = handlers.CheckBlockedError
+
+// FailOpenError carries the cause (and stage) of a guard which failed open
+type FailOpenError = handlers.FailOpenError
+
+// Outcome describes how guarded work went, see Guard.EndWith
+type Outcome = handlers.Outcome
+
+// ErrorClass returns the low cardinality class EndWith records errors by
+func ErrorClass(err error) string {
+	return handlers.ErrorClass(err)
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 
+	"github.com/StanzaSystems/sdk-go/global"
 	"github.com/StanzaSystems/sdk-go/handlers"
 	"github.com/StanzaSystems/sdk-go/handlers/grpchandler"
 	"github.com/StanzaSystems/sdk-go/handlers/httphandler"
@@ -16,6 +18,8 @@ import (
 	"google.golang.org/grpc"
 )
 
+// GuardOpt holds optional guard settings. It implements Option, new code
+// should prefer WithFeature, WithPriorityBoost, WithWeight, WithTags, etc.
 type GuardOpt struct {
 	Feature       *string
 	PriorityBoost *int32
@@ -24,14 +28,18 @@ type GuardOpt struct {
 }
 
 // HttpServer is a helper function to Guard inbound HTTP requests
-func HttpServer(guardName string, opts ...GuardOpt) (*httphandler.InboundHandler, error) {
-	return NewHttpInboundHandler(withOpts(guardName, opts...))
+func HttpServer(guardName string, opts ...Option) (*httphandler.InboundHandler, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return httphandler.NewInboundHandlerWithOptions(guardName, o)
 }
 
-func GuardMiddleware(next func(w http.ResponseWriter, r *http.Request), guardName string, opts ...GuardOpt) func(w http.ResponseWriter, r *http.Request) {
-	h, err := NewHttpInboundHandler(withOpts(guardName, opts...))
+func GuardMiddleware(next func(w http.ResponseWriter, r *http.Request), guardName string, opts ...Option) func(w http.ResponseWriter, r *http.Request) {
+	h, err := HttpServer(guardName, opts...)
 	if err != nil {
-		logging.Error(fmt.Errorf("no HTTP inbound handler, failing open"))
+		logging.Error(fmt.Errorf("no HTTP inbound handler, failing open: %w", err))
 		if h != nil {
 			h.FailOpen(context.Background())
 		}
@@ -40,10 +48,10 @@ func GuardMiddleware(next func(w http.ResponseWriter, r *http.Request), guardNam
 	return h.GuardHandlerFunction(next)
 }
 
-func GuardHandler(next http.Handler, guardName string, opts ...GuardOpt) http.Handler {
-	h, err := NewHttpInboundHandler(withOpts(guardName, opts...))
+func GuardHandler(next http.Handler, guardName string, opts ...Option) http.Handler {
+	h, err := HttpServer(guardName, opts...)
 	if err != nil {
-		logging.Error(fmt.Errorf("no HTTP inbound handler, failing open"))
+		logging.Error(fmt.Errorf("no HTTP inbound handler, failing open: %w", err))
 		if h != nil {
 			h.FailOpen(context.Background())
 		}
@@ -52,9 +60,18 @@ func GuardHandler(next http.Handler, guardName string, opts ...GuardOpt) http.Ha
 	return h.GuardHandler(next)
 }
 
+// HttpClient is a helper function to Guard outbound HTTP requests
+func HttpClient(guardName string, opts ...Option) (*httphandler.OutboundHandler, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return httphandler.NewOutboundHandlerWithOptions(guardName, o)
+}
+
 // HttpGet is a helper function to Guard an outbound HTTP GET
-func HttpGet(ctx context.Context, guardName, url string, opts ...GuardOpt) (*http.Response, error) {
-	h, err := NewHttpOutboundHandler(withOpts(guardName, opts...))
+func HttpGet(ctx context.Context, guardName, url string, opts ...Option) (*http.Response, error) {
+	h, err := HttpClient(guardName, opts...)
 	if err != nil {
 		logging.Error(fmt.Errorf("failed to create HTTP outbound handler: %v", err))
 		return nil, err
@@ -63,8 +80,8 @@ func HttpGet(ctx context.Context, guardName, url string, opts ...GuardOpt) (*htt
 }
 
 // HttpPost is a helper function to Guard an outbound HTTP POST
-func HttpPost(ctx context.Context, guardName, url string, body io.Reader, opts ...GuardOpt) (*http.Response, error) {
-	h, err := NewHttpOutboundHandler(withOpts(guardName, opts...))
+func HttpPost(ctx context.Context, guardName, url string, body io.Reader, opts ...Option) (*http.Response, error) {
+	h, err := HttpClient(guardName, opts...)
 	if err != nil {
 		logging.Error(fmt.Errorf("failed to create HTTP outbound handler: %v", err))
 		return nil, err
@@ -72,9 +89,27 @@ func HttpPost(ctx context.Context, guardName, url string, body io.Reader, opts .
 	return h.Post(ctx, url, body)
 }
 
+// GrpcServer is a helper function to Guard inbound grpc requests
+func GrpcServer(guardName string, opts ...Option) (*grpchandler.InboundHandler, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return grpchandler.NewInboundHandlerWithOptions(guardName, o)
+}
+
+// GrpcClient is a helper function to Guard outbound grpc requests
+func GrpcClient(guardName string, opts ...Option) (*grpchandler.OutboundHandler, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return grpchandler.NewOutboundHandlerWithOptions(guardName, o)
+}
+
 // UnaryServerInterceptor is a helper function to Guard an inbound grpc unary server
-func UnaryServerInterceptor(guardName string, opts ...GuardOpt) grpc.UnaryServerInterceptor {
-	h, err := grpchandler.NewInboundHandler(withOpts(guardName, opts...))
+func UnaryServerInterceptor(guardName string, opts ...Option) grpc.UnaryServerInterceptor {
+	h, err := GrpcServer(guardName, opts...)
 	if err != nil {
 		logging.Error(err)
 		return nil
@@ -83,8 +118,8 @@ func UnaryServerInterceptor(guardName string, opts ...GuardOpt) grpc.UnaryServer
 }
 
 // StreamServerInterceptor is a helper function to Guard an inbound grpc streaming server
-func StreamServerInterceptor(guardName string, opts ...GuardOpt) grpc.StreamServerInterceptor {
-	h, err := grpchandler.NewInboundHandler(withOpts(guardName, opts...))
+func StreamServerInterceptor(guardName string, opts ...Option) grpc.StreamServerInterceptor {
+	h, err := GrpcServer(guardName, opts...)
 	if err != nil {
 		logging.Error(err)
 		return nil
@@ -93,8 +128,8 @@ func StreamServerInterceptor(guardName string, opts ...GuardOpt) grpc.StreamServ
 }
 
 // UnaryClientInterceptor is a helper function to Guard an outbound grpc unary client
-func UnaryClientInterceptor(guardName string, opts ...GuardOpt) grpc.UnaryClientInterceptor {
-	h, err := grpchandler.NewOutboundHandler(withOpts(guardName, opts...))
+func UnaryClientInterceptor(guardName string, opts ...Option) grpc.UnaryClientInterceptor {
+	h, err := GrpcClient(guardName, opts...)
 	if err != nil {
 		logging.Error(err)
 		return nil
@@ -103,8 +138,8 @@ func UnaryClientInterceptor(guardName string, opts ...GuardOpt) grpc.UnaryClient
 }
 
 // StreamClientInterceptor is a helper function to Guard an outbound grpc streaming client
-func StreamClientInterceptor(guardName string, opts ...GuardOpt) grpc.StreamClientInterceptor {
-	h, err := grpchandler.NewOutboundHandler(withOpts(guardName, opts...))
+func StreamClientInterceptor(guardName string, opts ...Option) grpc.StreamClientInterceptor {
+	h, err := GrpcClient(guardName, opts...)
 	if err != nil {
 		logging.Error(err)
 		return nil
@@ -112,12 +147,15 @@ func StreamClientInterceptor(guardName string, opts ...GuardOpt) grpc.StreamClie
 	return h.NewStreamClientInterceptor()
 }
 
+// handlers of guards used without options, so Guard doesn't create one per call
+var plainHandlers sync.Map // guard name -> *handlers.Handler
+
 // Guard is a helper function to Guard any arbitrary block of code
-func Guard(ctx context.Context, guardName string, opts ...GuardOpt) *handlers.Guard {
-	h, err := handlers.NewHandler(withOpts(guardName, opts...))
+func Guard(ctx context.Context, guardName string, opts ...Option) *handlers.Guard {
+	h, err := guardHandler(guardName, opts...)
 	if err != nil {
-		err = fmt.Errorf("failed to create guard handler: %s", err)
 		logging.Error(err)
+		h, _ := plainHandler(guardName)
 		return h.NewGuard(ctx, nil, nil, err)
 	}
 	traceOpts := []trace.SpanStartOption{
@@ -129,30 +167,37 @@ func Guard(ctx context.Context, guardName string, opts ...GuardOpt) *handlers.Gu
 	return h.Guard(ctx, span, nil)
 }
 
+// guardHandler returns the handler for a guard, shared by every call without
+// options (options aren't comparable, so those get a handler of their own)
+func guardHandler(guardName string, opts ...Option) (*handlers.Handler, error) {
+	if len(opts) == 0 {
+		return plainHandler(guardName)
+	}
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	h, err := handlers.NewHandlerWithOptions(guardName, o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create guard handler: %s", err)
+	}
+	return h, nil
+}
+
+func plainHandler(guardName string) (*handlers.Handler, error) {
+	if h, ok := plainHandlers.Load(guardName); ok {
+		return h.(*handlers.Handler), nil
+	}
+	h, err := handlers.NewHandlerWithOptions(guardName, handlers.Options{})
+	if err != nil || !global.Initialized() {
+		return h, err // not cached until the client, service, etc are known
+	}
+	shared, _ := plainHandlers.LoadOrStore(guardName, h)
+	return shared.(*handlers.Handler), nil
+}
+
 // ContextWithHeaders is a helper function which extracts and OTEL TraceContext, Baggage,
 // and StanzaHeaders from a given http.Request into a context.Context.
 func ContextWithHeaders(r *http.Request) context.Context {
 	return otel.ContextWithHeaders(r)
 }
-
-func withOpts(gn string, opts ...GuardOpt) (string, *string, *int32, *float32, *map[string]string) {
-	var fn *string
-	var pb *int32
-	var dw *float32
-	var kv *map[string]string
-	if len(opts) == 1 {
-		if opts[0].Feature != nil {
-			fn = opts[0].Feature
-		}
-		if opts[0].PriorityBoost != nil {
-			pb = opts[0].PriorityBoost
-		}
-		if opts[0].DefaultWeight != nil {
-			dw = opts[0].DefaultWeight
-		}
-		if opts[0].Tags != nil {
-			kv = opts[0].Tags
-		}
-	}
-	return gn, fn, pb, dw, kv
-}
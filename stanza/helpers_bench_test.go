@@ -0,0 +1,37 @@
+package stanza
+
+import (
+	"context"
+	"testing"
+
+	hubv1 "buf.build/gen/go/stanza/apis/protocolbuffers/go/stanza/hub/v1"
+	"github.com/StanzaSystems/sdk-go/internal/hubtest"
+)
+
+// run with -benchmem, the plain stanza.Guard path should allocate about as
+// much as handlers.BenchmarkGuard/disabled
+func BenchmarkGuard(b *testing.B) {
+	hub, err := hubtest.Start()
+	if err != nil {
+		b.Fatal(err)
+	}
+	bench := func(b *testing.B, guard string, opts ...Option) {
+		hub.SetGuardConfig(guard, &hubv1.GuardConfig{})
+		ctx := context.Background()
+		g := Guard(ctx, guard, opts...) // fetch the guard config
+		g.End(g.Success)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			g := Guard(ctx, guard, opts...)
+			g.End(g.Success)
+		}
+	}
+	b.Run("plain", func(b *testing.B) {
+		bench(b, "bench-stanza-guard")
+	})
+	b.Run("options", func(b *testing.B) {
+		bench(b, "bench-stanza-guard-options", WithFeature("checkout"))
+	})
+}
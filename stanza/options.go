@@ -0,0 +1,447 @@
+package stanza
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/StanzaSystems/sdk-go/handlers"
+	"github.com/StanzaSystems/sdk-go/limiter"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Option configures a guard. Options compose, when the same setting is given
+// more than once the last one wins (tags are merged). GuardOpt is an Option
+// too, so existing callers passing GuardOpt values keep working. Helpers used
+// to take ...GuardOpt, callers spreading a []GuardOpt slice (opts...) need to
+// convert it with GuardOpts.
+type Option interface {
+	apply(*handlers.Options) error
+}
+
+type optionFunc func(*handlers.Options) error
+
+func (f optionFunc) apply(o *handlers.Options) error {
+	return f(o)
+}
+
+// WithFeature sets the feature name (overrides request baggage, if any)
+func WithFeature(name string) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if name == "" {
+			return errors.New("feature name must not be empty")
+		}
+		o.Feature = &name
+		return nil
+	})
+}
+
+// WithPriorityBoost sets the priority boost (adds to request baggage, if any)
+func WithPriorityBoost(boost int32) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		o.PriorityBoost = &boost
+		return nil
+	})
+}
+
+// WithWeight sets the default request weight (can't be set via request baggage)
+func WithWeight(weight float32) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if weight <= 0 || math.IsInf(float64(weight), 0) || math.IsNaN(float64(weight)) {
+			return fmt.Errorf("invalid weight %v, must be a positive number", weight)
+		}
+		o.DefaultWeight = &weight
+		return nil
+	})
+}
+
+// WithTags adds quota tags (only tags listed in the guard's config are used)
+func WithTags(tags map[string]string) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		for k, v := range tags {
+			if err := addTag(o, k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WithTag adds a single quota tag
+func WithTag(key, value string) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		return addTag(o, key, value)
+	})
+}
+
+// WithTagExtractors fills in quota tags from each request handled by the HTTP,
+// gRPC, or fiber middleware (see TagFromHeader, TagFromJWTClaim,
+// TagFromPathValue, and TagFromBaggage). Extracted tags are only used by this
+// guard, not by other guards called with the request context. Every distinct
+// value gets quota leases of its own, so set TagExtractor.Allowed (or
+// MaxValues) to bound the values clients can make us use.
+func WithTagExtractors(extractors ...TagExtractor) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		for _, te := range extractors {
+			if te.Tag == "" || te.Value == nil {
+				return errors.New("tag extractor must have a tag and a value function")
+			}
+			if te.MaxValues < 0 {
+				return fmt.Errorf("invalid max values %d for tag %q, must not be negative", te.MaxValues, te.Tag)
+			}
+		}
+		o.TagExtractors = append(append([]TagExtractor{}, o.TagExtractors...), extractors...)
+		return nil
+	})
+}
+
+// WithTimeout sets the maximum time to wait on Stanza Hub (for config, token,
+// and quota checks) before failing open
+func WithTimeout(d time.Duration) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid timeout %v, must be greater than zero", d)
+		}
+		o.Timeout = d
+		return nil
+	})
+}
+
+// WithClassifier sets the function which decides whether an error returned by
+// guarded work counts as a Failure (by default, any non-nil error does)
+func WithClassifier(isFailure func(error) bool) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if isFailure == nil {
+			return errors.New("classifier must not be nil")
+		}
+		o.IsFailure = isFailure
+		return nil
+	})
+}
+
+// WithGrpcCodes overrides the gRPC status codes returned for blocked requests,
+// keyed by block reason (ReasonLocalBlocked, ReasonTokenNotValid, or
+// ReasonQuotaBlocked). By default token failures return Unauthenticated and
+// everything else returns ResourceExhausted.
+func WithGrpcCodes(m map[string]codes.Code) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		grpcCodes := map[string]codes.Code{}
+		for k, v := range o.GrpcCodes {
+			grpcCodes[k] = v
+		}
+		for reason, code := range m {
+			switch reason {
+			case handlers.ReasonLocalBlocked, handlers.ReasonTokenNotValid, handlers.ReasonQuotaBlocked:
+			default:
+				return fmt.Errorf("unknown block reason %q", reason)
+			}
+			if code == codes.OK {
+				return fmt.Errorf("invalid status code %v for blocked requests", code)
+			}
+			grpcCodes[reason] = code
+		}
+		o.GrpcCodes = grpcCodes
+		return nil
+	})
+}
+
+// WithBlockedHandler sets the function which writes the response for requests
+// blocked by HTTP middleware (see httphandler.BlockedText, BlockedJSON, and
+// BlockedProblem). It can also serve a degraded response, for example from a
+// cache.
+func WithBlockedHandler(fn func(http.ResponseWriter, *http.Request, *handlers.Guard)) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if fn == nil {
+			return errors.New("blocked handler must not be nil")
+		}
+		o.BlockedHandler = fn
+		return nil
+	})
+}
+
+// WithAutoEnd ends allowed guards with Unknown status when their context is
+// done, for callers which might not call Guard.End themselves
+func WithAutoEnd() Option {
+	return optionFunc(func(o *handlers.Options) error {
+		o.AutoEnd = true
+		return nil
+	})
+}
+
+// WithFastPath skips the guard pipeline (and its spans, metrics, and logs)
+// while the guard's config has nothing to check: no quota, no ingress tokens,
+// and no Sentinel rules. Meant for very high QPS guards which are usually
+// disabled; it has no effect with WithShadow, custom checks, or local limits.
+func WithFastPath() Option {
+	return optionFunc(func(o *handlers.Options) error {
+		o.FastPath = true
+		return nil
+	})
+}
+
+// WithShadow evaluates the guard in shadow mode: every check runs and
+// would-block metrics, spans, and logs are reported (with a shadow=true
+// attribute), but requests are always allowed and no real quota is consumed.
+// Use ShadowContext to dry-run a single call instead.
+//
+// Stanza Hub can't check quota without consuming it, so the quota check is
+// estimated from what the SDK already knows: it would block if quota for the
+// same guard, feature, and tags was recently blocked, and would be granted if
+// there is a cached lease for it. Otherwise (like for a guard which has never
+// enforced) its quota_reason is QUOTA_SHADOW_UNKNOWN rather than granted.
+// Likewise, shadow guards never hold a concurrency slot or Sentinel entry (so
+// they don't use up capacity of enforced traffic), local checks are estimated
+// from current in-flight counts, statistics, and open circuit breakers.
+func WithShadow() Option {
+	return optionFunc(func(o *handlers.Options) error {
+		o.Shadow = true
+		return nil
+	})
+}
+
+// WithCheck adds a custom Check to the guard pipeline. It runs after the
+// built-in config, local, and token checks (and any earlier WithCheck), but
+// before the quota check so blocked requests never consume quota. Its name
+// must be unique and not that of a built-in stage, and if it panics the guard
+// fails open.
+func WithCheck(c Check) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		checks := o.Checks
+		if checks == nil {
+			checks = handlers.DefaultChecks()
+		}
+		i := len(checks)
+		for j, existing := range checks {
+			if existing == handlers.QuotaCheck {
+				i = j
+			}
+		}
+		checks, err := handlers.ValidateChecks(append(append(append([]Check{}, checks[:i]...), c), checks[i:]...))
+		if err != nil {
+			return err
+		}
+		o.Checks = checks
+		return nil
+	})
+}
+
+// WithChecks sets the whole guard pipeline, in order. Include the built-in
+// ConfigCheck, LocalCheck, TokenCheck, and QuotaCheck stages where wanted
+// (ConfigCheck always runs first, and TokenCheck and QuotaCheck need it).
+// Check names must be unique, and custom checks can't use the name of a
+// built-in stage. A custom check which panics fails open.
+func WithChecks(checks ...Check) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		checks, err := handlers.ValidateChecks(checks)
+		if err != nil {
+			return err
+		}
+		o.Checks = checks
+		return nil
+	})
+}
+
+// WithConcurrencyLimit limits how many guarded requests may be in flight
+// (between Guard and End) at the same time, requests over the limit are
+// blocked with a local_reason of LOCAL_CONCURRENCY_LIMITED
+func WithConcurrencyLimit(limit int) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if limit <= 0 {
+			return fmt.Errorf("invalid concurrency limit %d, must be greater than zero", limit)
+		}
+		o.ConcurrencyLimit = limit
+		return nil
+	})
+}
+
+// WithAdaptiveLimit limits how many guarded requests may be in flight at the
+// same time, with a limit continuously adjusted from observed guard latency and
+// failures by the Algorithm returned from newAlgorithm (see WithAIMDLimit,
+// WithVegasLimit, and WithGradient2Limit). Requests over the limit are blocked
+// with a local_reason of LOCAL_CONCURRENCY_LIMITED.
+func WithAdaptiveLimit(newAlgorithm func() limiter.Algorithm) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if newAlgorithm == nil {
+			return errors.New("adaptive limit algorithm must not be nil")
+		}
+		o.AdaptiveLimit = newAlgorithm
+		return nil
+	})
+}
+
+// WithAIMDLimit is WithAdaptiveLimit using the AIMD algorithm
+func WithAIMDLimit(opts limiter.AIMDOptions) Option {
+	return WithAdaptiveLimit(func() limiter.Algorithm { return limiter.NewAIMD(opts) })
+}
+
+// WithVegasLimit is WithAdaptiveLimit using the Vegas algorithm
+func WithVegasLimit(opts limiter.VegasOptions) Option {
+	return WithAdaptiveLimit(func() limiter.Algorithm { return limiter.NewVegas(opts) })
+}
+
+// WithGradient2Limit is WithAdaptiveLimit using the Gradient2 algorithm
+func WithGradient2Limit(opts limiter.Gradient2Options) Option {
+	return WithAdaptiveLimit(func() limiter.Algorithm { return limiter.NewGradient2(opts) })
+}
+
+// WithFeatureConcurrencyLimit limits how many guarded requests for a feature
+// may be in flight at the same time (in addition to any WithConcurrencyLimit)
+func WithFeatureConcurrencyLimit(feature string, limit int) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if feature == "" {
+			return errors.New("feature name must not be empty")
+		}
+		if limit <= 0 {
+			return fmt.Errorf("invalid concurrency limit %d, must be greater than zero", limit)
+		}
+		limits := map[string]int{}
+		for k, v := range o.FeatureConcurrencyLimits {
+			limits[k] = v
+		}
+		limits[feature] = limit
+		o.FeatureConcurrencyLimits = limits
+		return nil
+	})
+}
+
+// WithFeaturePriority sets the base local priority of a feature (see
+// limiter.PriorityBackground through limiter.PriorityCritical, the default is
+// limiter.PriorityNormal). A request's PriorityBoost is added to it, and under
+// local overload (see WithPriorityShedding and WithCPUThreshold) the lowest
+// priority traffic is shed first.
+func WithFeaturePriority(feature string, priority int32) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if feature == "" {
+			return errors.New("feature name must not be empty")
+		}
+		priorities := map[string]int32{}
+		for k, v := range o.FeaturePriorities {
+			priorities[k] = v
+		}
+		priorities[feature] = priority
+		o.FeaturePriorities = priorities
+		return nil
+	})
+}
+
+// WithPriorityShedding sheds low priority traffic first as in-flight requests
+// approach the guard's concurrency limits (see WithConcurrencyLimit): each
+// priority band may only use its share of a limit (limiter.DEFAULT_BAND_SHARES,
+// 90% for PriorityNormal), leaving the rest for more important traffic. Shed
+// requests are blocked with a local_reason of LOCAL_PRIORITY_SHED. Without it
+// the limits apply to all traffic alike.
+func WithPriorityShedding() Option {
+	return optionFunc(func(o *handlers.Options) error {
+		o.PriorityShedding = true
+		return nil
+	})
+}
+
+// WithCPUThreshold sheds low priority traffic first as process CPU usage
+// (0-1, across all cores) approaches threshold
+func WithCPUThreshold(threshold float64) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if threshold <= 0 || threshold > 1 {
+			return fmt.Errorf("invalid CPU threshold %v, must be between 0 and 1", threshold)
+		}
+		o.CPUThreshold = threshold
+		return nil
+	})
+}
+
+// WithLeakTimeout sets how long a guard may hold a concurrency slot before it
+// is reported as leaked (never ended) and the slot is freed
+func WithLeakTimeout(d time.Duration) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid leak timeout %v, must be greater than zero", d)
+		}
+		o.LeakTimeout = d
+		return nil
+	})
+}
+
+// RetryPolicy retries (and optionally hedges) outbound calls, see WithRetry
+type RetryPolicy = handlers.RetryPolicy
+
+// WithRetry retries failed outbound calls (HTTP requests and unary gRPC
+// calls), every attempt goes through the guard again. The policy's Budget is
+// the fraction of calls which may be retried, between 0 (for
+// handlers.DEFAULT_RETRY_BUDGET) and 1.
+func WithRetry(p RetryPolicy) Option {
+	return optionFunc(func(o *handlers.Options) error {
+		if p.MaxAttempts < 1 {
+			return fmt.Errorf("invalid max attempts %d, must be at least 1", p.MaxAttempts)
+		}
+		if p.Backoff < 0 || p.MaxBackoff < 0 || p.HedgeAfter < 0 {
+			return errors.New("invalid retry policy, backoff and hedge delays must not be negative")
+		}
+		if !(p.Budget >= 0 && p.Budget <= 1) {
+			return fmt.Errorf("invalid retry budget %v, must be between 0 (for the default) and 1", p.Budget)
+		}
+		o.Retry = &p
+		return nil
+	})
+}
+
+// GuardOpts converts a slice of GuardOpt into Options, for callers which
+// spread an existing []GuardOpt into a helper (like HttpServer)
+func GuardOpts(opts []GuardOpt) []Option {
+	options := make([]Option, len(opts))
+	for i, opt := range opts {
+		options[i] = opt
+	}
+	return options
+}
+
+// apply allows the GuardOpt struct to be used as an Option
+func (g GuardOpt) apply(o *handlers.Options) error {
+	if g.Feature != nil {
+		o.Feature = g.Feature
+	}
+	if g.PriorityBoost != nil {
+		o.PriorityBoost = g.PriorityBoost
+	}
+	if g.DefaultWeight != nil {
+		o.DefaultWeight = g.DefaultWeight
+	}
+	if g.Tags != nil {
+		for k, v := range *g.Tags {
+			if err := addTag(o, k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addTag(o *handlers.Options, key, value string) error {
+	if key == "" {
+		return errors.New("tag key must not be empty")
+	}
+	tags := map[string]string{}
+	if o.Tags != nil {
+		for k, v := range *o.Tags {
+			tags[k] = v
+		}
+	}
+	tags[key] = value
+	o.Tags = &tags
+	return nil
+}
+
+func newOptions(opts ...Option) (handlers.Options, error) {
+	o := handlers.Options{}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt.apply(&o); err != nil {
+			return o, fmt.Errorf("invalid guard option: %w", err)
+		}
+	}
+	return o, nil
+}
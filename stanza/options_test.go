@@ -0,0 +1,27 @@
+package stanza
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuardOpts(t *testing.T) {
+	feature := "checkout"
+	opts := []GuardOpt{{Feature: &feature}}
+	o, err := newOptions(GuardOpts(opts)...)
+	assert.NoError(t, err)
+	assert.Equal(t, "checkout", *o.Feature)
+}
+
+func TestWithRetryBudget(t *testing.T) {
+	for _, budget := range []float64{0, 0.2, 1} {
+		_, err := newOptions(WithRetry(RetryPolicy{MaxAttempts: 2, Budget: budget}))
+		assert.NoError(t, err, budget)
+	}
+	for _, budget := range []float64{-0.1, 1.5, math.Inf(1), math.NaN()} {
+		_, err := newOptions(WithRetry(RetryPolicy{MaxAttempts: 2, Budget: budget}))
+		assert.Error(t, err, budget)
+	}
+}
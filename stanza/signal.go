@@ -0,0 +1,33 @@
+package stanza
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ShutdownOnSignal is an opt-in helper which calls done (typically the
+// shutdown function returned by Init) when the process receives one of the
+// given signals. If no signals are given, it listens for SIGINT and SIGTERM.
+// The returned stop function stops listening for signals.
+func ShutdownOnSignal(done func(), sig ...os.Signal) (stop func()) {
+	if len(sig) == 0 {
+		sig = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	quit := make(chan struct{})
+	signal.Notify(c, sig...)
+	go func() {
+		select {
+		case <-c:
+			done()
+		case <-quit:
+		}
+		signal.Stop(c)
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(quit) })
+	}
+}
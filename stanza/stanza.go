@@ -3,9 +3,13 @@ package stanza
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/StanzaSystems/sdk-go/global"
+	"github.com/StanzaSystems/sdk-go/handlers"
 	"github.com/StanzaSystems/sdk-go/otel"
 )
 
@@ -13,19 +17,56 @@ type ClientOptions struct {
 	// Required
 	APIKey string // customer generated API key
 
+	// Optional alternatives to APIKey which allow rotating keys without a restart;
+	// the key is (re-)read before every hub request and again on auth failures.
+	APIKeyProvider func() (string, error) // returns the current API key
+	APIKeyFile     string                 // path to a file containing the API key (checked for changes every 10 seconds)
+
 	// Optional
 	Name        string // defines service unique name
 	Release     string // defines service version
 	Environment string // defines service environment
 	StanzaHub   string // host:port (ipv4, ipv6, or resolvable hostname)
 
-	Guard []string // prefetch config for these guards
+	// Optional list of hub endpoints in priority order, we fail over to the next
+	// endpoint when the active one is unreachable. Endpoints may be host:port,
+	// a gRPC target (like dns:///host:port), or a DNS SRV name (_service._tcp.name).
+	// If set, StanzaHub is used as the first (highest priority) endpoint.
+	// Endpoints are dialed in parallel, and we use the highest priority one which
+	// is reachable. Set STANZA_HUB_SELECTION=latency to use whichever endpoint
+	// connects first instead (the closest region, usually). SRV names are
+	// resolved again whenever we fail over.
+	StanzaHubs []string
+
+	// Prefetch config for these guards. Guards used by handlers and middleware
+	// (HttpServer, GuardHandler, UnaryServerInterceptor, etc) are discovered and
+	// prefetched automatically when they are created, list any other guards here
+	// (by their full names, Stanza Hub can't list a service's guards).
+	Guard []string
 }
 
+// ErrInitialized is returned by Init when the SDK is already initialized
+var ErrInitialized = global.ErrInitialized
+
 // Init initializes the SDK with ClientOptions. The returned error is
 // non-nil if options is invalid, if a global client already exists, or
-// if StanzaHub can't be reached.
+// if StanzaHub can't be reached. The returned shutdown function is never nil,
+// call it even if there was an error (the SDK may keep running).
+//
+// The SDK runs until ctx is done or the returned shutdown function is called.
+// It does not listen for OS signals itself, see ShutdownOnSignal if you want that.
 func Init(ctx context.Context, co ClientOptions) (func(), error) {
+	keyProvider := co.APIKeyProvider
+	if keyProvider == nil && co.APIKeyFile != "" {
+		keyProvider = global.KeyFileProvider(co.APIKeyFile)
+	}
+	if keyProvider != nil {
+		key, err := keyProvider()
+		if err != nil {
+			return func() {}, fmt.Errorf("failed to read Stanza API key: %w", err)
+		}
+		co.APIKey = key
+	}
 	if co.APIKey == "" {
 		if os.Getenv("STANZA_API_KEY") != "" {
 			co.APIKey = os.Getenv("STANZA_API_KEY")
@@ -57,12 +98,26 @@ func Init(ctx context.Context, co ClientOptions) (func(), error) {
 			co.Environment = "dev"
 		}
 	}
-	if co.StanzaHub == "" {
+	hubs := co.StanzaHubs
+	if co.StanzaHub != "" {
+		hubs = append([]string{co.StanzaHub}, hubs...)
+	}
+	if len(hubs) == 0 {
 		if os.Getenv("STANZA_HUB_ADDRESS") != "" {
-			co.StanzaHub = os.Getenv("STANZA_HUB_ADDRESS")
+			// comma separated, in priority order
+			hubs = strings.Split(os.Getenv("STANZA_HUB_ADDRESS"), ",")
 		} else {
-			co.StanzaHub = "hub.stanzasys.co:9020"
+			hubs = []string{"hub.stanzasys.co:9020"}
+		}
+	}
+
+	// Debug mode, report guards which aren't ended within a threshold
+	if os.Getenv("STANZA_DEBUG_GUARDS") != "" {
+		threshold, err := time.ParseDuration(os.Getenv("STANZA_DEBUG_GUARDS"))
+		if err != nil {
+			return func() {}, fmt.Errorf("invalid STANZA_DEBUG_GUARDS threshold: %w", err)
 		}
+		TrackGuards(threshold)
 	}
 
 	// Set global propagation, we do this here since **propagation** is something
@@ -70,19 +125,57 @@ func Init(ctx context.Context, co ClientOptions) (func(), error) {
 	otel.InitTextMapPropagator(otel.StanzaHeaders{})
 
 	// Initialize new global state
-	hubDone := global.NewState(ctx,
-		co.StanzaHub,
+	hubDone, err := global.NewState(ctx,
+		hubs,
 		co.APIKey,
+		keyProvider,
 		co.Name,
 		co.Environment,
 		co.Release,
 		co.Guard,
 	)
 
-	// Return graceful shutdown function (to be deferred by the caller)
-	return hubDone, nil
+	// Return graceful shutdown function (to be deferred by the caller), along
+	// with ErrInitialized if a global client already exists (and the shutdown
+	// function is a no-op) or an error if no hub endpoint could be resolved
+	return hubDone, err
+}
+
+// Health returns the current state of the SDK's connection to Stanza Hub,
+// including which hub endpoint is active.
+func Health() global.Health {
+	return global.GetHealth()
+}
+
+// TrackGuards is a debugging aid which reports (with their creation stack
+// traces) allowed guards that haven't been ended within threshold, zero
+// disables it. It can also be enabled with the STANZA_DEBUG_GUARDS
+// environment variable (for example "STANZA_DEBUG_GUARDS=30s"). Build with
+// "-tags stanzadev" to also warn about guards garbage collected without End.
+func TrackGuards(threshold time.Duration) {
+	handlers.TrackGuards(threshold)
 }
 
-func RegisterGuard(ctx context.Context, guard string) {
-	global.GetGuardConfig(ctx, guard)
+// ShadowContext returns a context whose guards are evaluated in shadow mode
+// (see WithShadow), use Guard.WouldBlock to see what they would have decided
+func ShadowContext(ctx context.Context) context.Context {
+	return handlers.ContextWithShadow(ctx)
+}
+
+// RegisterGuard fetches (and keeps polling for) the given guard's config,
+// blocking until the first fetch has completed. Guards registered before Init
+// are queued, and fetched along with the initial configs.
+//
+// Stanza Hub has no way to list the guards configured for a service, so
+// guard must be a full guard name (wildcards and prefixes aren't supported).
+func RegisterGuard(ctx context.Context, guard string) error {
+	if guard == "" || strings.ContainsAny(guard, "*?[") {
+		return fmt.Errorf("invalid guard name %q, guards must be registered by their full name", guard)
+	}
+	if !global.Initialized() {
+		global.RegisterGuard(guard)
+		return nil
+	}
+	_, _, err := global.GetGuardConfig(ctx, guard)
+	return err
 }
@@ -0,0 +1,17 @@
+package stanza
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterGuard(t *testing.T) {
+	ctx := context.Background()
+	assert.Error(t, RegisterGuard(ctx, ""))
+	assert.Error(t, RegisterGuard(ctx, "checkout-*"))
+
+	// queued until Init, instead of panicking
+	assert.NoError(t, RegisterGuard(ctx, "checkout"))
+}
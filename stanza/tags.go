@@ -0,0 +1,40 @@
+package stanza
+
+import (
+	"context"
+
+	"github.com/StanzaSystems/sdk-go/handlers"
+)
+
+// TagExtractor fills in a quota tag from each request (see WithTagExtractors)
+type TagExtractor = handlers.TagExtractor
+
+// TagFromHeader extracts a quota tag from an HTTP header or gRPC metadata key
+func TagFromHeader(tag, header string) TagExtractor {
+	return handlers.TagFromHeader(tag, header)
+}
+
+// TagFromPathValue extracts a quota tag from a path parameter (http.ServeMux
+// wildcards, or fiber route parameters)
+func TagFromPathValue(tag, name string) TagExtractor {
+	return handlers.TagFromPathValue(tag, name)
+}
+
+// TagFromBaggage extracts a quota tag from an OTEL baggage member
+func TagFromBaggage(tag, member string) TagExtractor {
+	return handlers.TagFromBaggage(tag, member)
+}
+
+// TagFromJWTClaim extracts a quota tag from a claim of the bearer token in the
+// Authorization header. The token's signature is NOT verified, so clients can
+// put anything in the claim: authenticate requests separately, and consider
+// setting Allowed on the returned TagExtractor.
+func TagFromJWTClaim(tag, claim string) TagExtractor {
+	return handlers.TagFromJWTClaim(tag, claim)
+}
+
+// ContextWithTags returns a context carrying quota tags for the guards of a
+// single call
+func ContextWithTags(ctx context.Context, tags map[string]string) context.Context {
+	return handlers.ContextWithTags(ctx, tags)
+}